@@ -0,0 +1,219 @@
+package ecrm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/goccy/go-yaml"
+	oci "github.com/google/go-containerregistry/pkg/v1"
+	ociTypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/samber/lo"
+)
+
+// retentionSafetyMargin is a multiplier of the suggested expires and keep_count.
+const retentionSafetyMargin = 1.5
+
+// retentionAnalysis is a result of the analysis of the push history of a repository.
+type retentionAnalysis struct {
+	images          int       // number of the container images and image indexes (except for their child manifests)
+	taggedImages    int       // number of the tagged images
+	inUse           int       // number of the images in use
+	oldestInUse     time.Time // pushed time of the oldest image in use
+	oldestInUseRank int64     // rank of the oldest tagged image in use in the tagged images, newest first
+	rankedImages    int       // number of the tagged images in the repository of oldestInUseRank
+}
+
+// merge merges the analysis of another repository, for the entries of name patterns.
+func (a *retentionAnalysis) merge(b *retentionAnalysis) {
+	a.images += b.images
+	a.taggedImages += b.taggedImages
+	a.inUse += b.inUse
+	if !b.oldestInUse.IsZero() && (a.oldestInUse.IsZero() || b.oldestInUse.Before(a.oldestInUse)) {
+		a.oldestInUse = b.oldestInUse
+	}
+	// the rank is meaningful only in a repository
+	if b.oldestInUseRank > a.oldestInUseRank {
+		a.oldestInUseRank = b.oldestInUseRank
+		a.rankedImages = b.rankedImages
+	}
+}
+
+// suggest suggests expires and keep_count of the repository config, that would have kept every image in use with a safety margin.
+// The reasons are set to the annotations of the config, output as YAML comments.
+func (a *retentionAnalysis) suggest(rc *RepositoryConfig, now time.Time) {
+	rc.annotations = make(map[string]string)
+	if a.inUse == 0 {
+		rc.annotations["expires"] = fmt.Sprintf("no images in use found in %d images", a.images)
+		return
+	}
+	days := int64(now.Sub(a.oldestInUse).Hours() / 24)
+	rc.Expires = fmt.Sprintf("%dd", max(int64(math.Ceil(float64(days)*retentionSafetyMargin)), 1))
+	rc.annotations["expires"] = fmt.Sprintf("the oldest image in use was pushed %d days ago", days)
+
+	if a.oldestInUseRank == 0 {
+		rc.annotations["keep_count"] = "images in use are untagged"
+		return
+	}
+	rc.KeepCount = max(int64(math.Ceil(float64(a.oldestInUseRank)*retentionSafetyMargin)), 1)
+	rc.annotations["keep_count"] = fmt.Sprintf("the oldest image in use is the %s newest of %d tagged images in the repository", ordinal(a.oldestInUseRank), a.rankedImages)
+}
+
+func ordinal(n int64) string {
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+	return fmt.Sprintf("%d%s", n, suffix)
+}
+
+// analyzeRetention scans the images in use and analyzes the push history of the repositories.
+func (g *Generator) analyzeRetention(ctx context.Context, d *discovered, opt *GenerateOption) (map[string]*retentionAnalysis, error) {
+	scanner := NewScanner(g.awsCfg, g.clientOptions...)
+	scanConfig := &Config{
+		Clusters:        []*ClusterConfig{{NamePattern: "*"}},
+		TaskDefinitions: []*TaskdefConfig{{NamePattern: "*", KeepCount: opt.KeepCount}},
+		LambdaFunctions: []*LambdaConfig{{NamePattern: "*", KeepCount: opt.KeepCount}},
+	}
+	if err := scanConfig.Validate(); err != nil {
+		return nil, err
+	}
+	if err := scanner.Scan(ctx, scanConfig); err != nil {
+		return nil, fmt.Errorf("failed to scan images in use: %w", err)
+	}
+	if err := scanner.LoadFiles(ctx, opt.ScannedFiles); err != nil {
+		return nil, err
+	}
+
+	keepImages := scanner.Images()
+	planner := NewPlanner(g.awsCfg, g.clientOptions...)
+	result := make(map[string]*retentionAnalysis, len(d.repositories))
+	for _, name := range d.repositories {
+		a, err := planner.analyzeRepository(ctx, RepositoryName(name), keepImages)
+		if err != nil {
+			return nil, err
+		}
+		result[name] = a
+	}
+	return result, nil
+}
+
+// analyzeRepository analyzes the push history of the repository with the images in use.
+func (p *Planner) analyzeRepository(ctx context.Context, name RepositoryName, keepImages Images) (*retentionAnalysis, error) {
+	log.Printf("[info] analyzing push history of %s", name)
+	imgs, err := p.listImageDetails(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	// tasks of multi-arch images refer to the image indexes or their child manifests.
+	// an image index is in use if it or its child manifest is in use, and it is ranked instead of the children.
+	children, err := p.imageIndexChildren(ctx, name, imgs.imageIndexes)
+	if err != nil {
+		return nil, err
+	}
+	inUse := newSet()
+	var candidates []ecrTypes.ImageDetail
+	for _, img := range imgs.images {
+		index, isChild := children[aws.ToString(img.ImageDigest)]
+		if !p.isInUse(img, keepImages) {
+			if !isChild {
+				candidates = append(candidates, img)
+			}
+			continue
+		}
+		if isChild {
+			inUse.add(index)
+		} else {
+			inUse.add(aws.ToString(img.ImageDigest))
+			candidates = append(candidates, img)
+		}
+	}
+	for _, img := range imgs.imageIndexes {
+		if p.isInUse(img, keepImages) {
+			inUse.add(aws.ToString(img.ImageDigest))
+		}
+		candidates = append(candidates, img)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].ImagePushedAt.After(*candidates[j].ImagePushedAt)
+	})
+
+	a := &retentionAnalysis{images: len(candidates)}
+	for _, img := range candidates { // newest first
+		if _, tagged := imageTag(img); tagged {
+			a.taggedImages++
+		}
+		if !inUse.contains(aws.ToString(img.ImageDigest)) {
+			continue
+		}
+		a.inUse++
+		a.oldestInUse = *img.ImagePushedAt
+		if _, tagged := imageTag(img); tagged {
+			a.oldestInUseRank = int64(a.taggedImages)
+		}
+	}
+	a.rankedImages = a.taggedImages
+	log.Printf("[debug] %s: %d images, %d in use, the oldest in use %s", name, a.images, a.inUse, a.oldestInUse)
+	return a, nil
+}
+
+// imageIndexChildren returns the digests of the manifests referred by the image indexes, mapped to the digests of the indexes.
+func (p *Planner) imageIndexChildren(ctx context.Context, repo RepositoryName, indexes []ecrTypes.ImageDetail) (map[string]string, error) {
+	children := make(map[string]string)
+	for _, c := range lo.Chunk(indexes, batchGetImageLimit) {
+		imageIds := make([]ecrTypes.ImageIdentifier, 0, len(c))
+		for _, d := range c {
+			imageIds = append(imageIds, ecrTypes.ImageIdentifier{ImageDigest: d.ImageDigest})
+		}
+		res, err := p.ecr.BatchGetImage(ctx, &ecr.BatchGetImageInput{
+			ImageIds:       imageIds,
+			RepositoryName: aws.String(string(repo)),
+			AcceptedMediaTypes: []string{
+				string(ociTypes.OCIImageIndex),
+				string(ociTypes.DockerManifestList),
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to batch get image: %w", err)
+		}
+		for _, img := range res.Images {
+			if img.ImageManifest == nil {
+				continue
+			}
+			var m oci.IndexManifest
+			if err := json.Unmarshal([]byte(*img.ImageManifest), &m); err != nil {
+				log.Printf("[warn] failed to parse manifest: %s %s", *img.ImageManifest, err)
+				continue
+			}
+			for _, d := range m.Manifests {
+				children[d.Digest.String()] = aws.ToString(img.ImageId.ImageDigest)
+			}
+		}
+	}
+	return children, nil
+}
+
+// repositoryComments returns YAML comments of the annotations of the repository configs.
+// path is the YAML path of the repositories. e.g. "$.repositories" or "$" for the sequence itself.
+func repositoryComments(path string, rcs []*RepositoryConfig) yaml.CommentMap {
+	comments := make(yaml.CommentMap)
+	for i, rc := range rcs {
+		for field, text := range rc.annotations {
+			comments[fmt.Sprintf("%s[%d].%s", path, i, field)] = []*yaml.Comment{yaml.LineComment(" " + text)}
+		}
+	}
+	return comments
+}
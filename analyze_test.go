@@ -0,0 +1,105 @@
+package ecrm_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/ecrm"
+)
+
+func TestGenerateConfigAnalyze(t *testing.T) {
+	now := time.Now()
+	opt := &ecrm.GenerateOption{
+		KeepCount:       5,
+		Expires:         "30d",
+		KeepTagPatterns: []string{"latest"},
+		MinGroupSize:    2,
+		Analyze:         true,
+	}
+	config := ecrm.DiscoveredRetentionConfig(nil, opt, map[string]*ecrm.RetentionAnalysis{
+		// oldest image in use: 40 days ago, 10th newest
+		"team/app":    ecrm.NewRetentionAnalysis(30, 20, 2, now.Add(-40*24*time.Hour), 10),
+		"team/worker": ecrm.NewRetentionAnalysis(10, 10, 1, now.Add(-10*24*time.Hour), 2),
+		"tools":       ecrm.NewRetentionAnalysis(3, 3, 0, time.Time{}, 0),
+		"untagged":    ecrm.NewRetentionAnalysis(5, 0, 1, now.Add(-2*24*time.Hour), 0),
+	})
+	if len(config.Repositories) != 3 {
+		t.Fatalf("unexpected repositories: %v", config.Repositories)
+	}
+	team, tools, untagged := config.Repositories[0], config.Repositories[1], config.Repositories[2]
+	if team.NamePattern != "team/*" || team.Expires != "60d" || team.KeepCount != 15 {
+		t.Errorf("unexpected repository config: %#v", team)
+	}
+	if tools.Name != "tools" || tools.Expires != "30d" || tools.KeepCount != 5 {
+		t.Errorf("unexpected repository config: %#v", tools)
+	}
+	if untagged.Name != "untagged" || untagged.Expires != "3d" || untagged.KeepCount != 5 {
+		t.Errorf("unexpected repository config: %#v", untagged)
+	}
+
+	b, err := ecrm.MarshalConfig(config, "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"expires: 60d # the oldest image in use was pushed 40 days ago",
+		"keep_count: 15 # the oldest image in use is the 10th newest of 20 tagged images in the repository",
+		"expires: 30d # no images in use found in 3 images",
+		"keep_count: 5 # images in use are untagged",
+	} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("generated config must contain %q\n%s", s, string(b))
+		}
+	}
+
+	// annotated entries are merged with comments
+	src, err := os.ReadFile("testdata/merge.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := ecrm.AppendConfigEntries(src, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(merged), "    expires: 60d # the oldest image in use was pushed 40 days ago\n") {
+		t.Errorf("merged config must contain the comments\n%s", string(merged))
+	}
+}
+
+func TestAnalyzeRepositoryImageIndex(t *testing.T) {
+	f := newFakeAWS()
+	now := time.Now()
+	for i, tag := range []string{"v1", "v2", "v3"} {
+		pushedAt := now.Add(-time.Duration(40-i*10) * 24 * time.Hour)
+		amd64 := f.addImage("multi", tag+"-amd64", pushedAt)
+		arm64 := f.addImage("multi", tag+"-arm64", pushedAt)
+		f.addImageIndex("multi", tag, pushedAt, []string{amd64, arm64}, tag)
+	}
+	planner := ecrm.NewPlanner(testAWSConfig(), f.clientOptions()...)
+
+	for _, tc := range []struct {
+		name  string
+		inUse ecrm.ImageURI
+		rank  int64
+	}{
+		{name: "child manifest", inUse: fakeImageURI("multi", fakeDigest("multi/v1-arm64")), rank: 3},
+		{name: "index tag", inUse: fakeImageURI("multi", "v2"), rank: 2},
+		{name: "index digest", inUse: fakeImageURI("multi", fakeDigest("multi/v3")), rank: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			keep := ecrm.Images{}
+			keep.AddUsage(tc.inUse, ecrm.UsedBy{ARN: "arn:aws:ecs:us-east-1:012345678901:task/default/0123456789abcdef"})
+			a, err := ecrm.AnalyzeRepository(context.Background(), planner, "multi", keep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			images, tagged, inUse, rank := a.Counts()
+			if images != 3 || tagged != 3 || inUse != 1 || rank != tc.rank {
+				t.Errorf("unexpected analysis: images:%d tagged:%d in use:%d rank:%d", images, tagged, inUse, rank)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/alecthomas/kong"
 	"github.com/fatih/color"
@@ -46,64 +47,112 @@ func SetLogLevel(level string) {
 }
 
 type CLI struct {
-	Config      string `help:"Load configuration from FILE" short:"c" default:"ecrm.yaml" env:"ECRM_CONFIG"`
+	Config      string `help:"Load configuration from FILE (or s3://bucket/key, ssm://parameter-name)" short:"c" default:"ecrm.yaml" env:"ECRM_CONFIG"`
 	LogLevel    string `help:"Set log level (debug, info, notice, warn, error)" default:"info" env:"ECRM_LOG_LEVEL"`
 	Color       bool   `help:"Whether or not to color the output" default:"true" env:"ECRM_COLOR" negatable:""`
 	ShowVersion bool   `help:"Show version." name:"version"`
 
-	Generate *GenerateCLI `cmd:"" help:"Generate a configuration file."`
-	Scan     *ScanCLI     `cmd:"" help:"Scan ECS/Lambda resources. Output image URIs in use."`
-	Plan     *PlanCLI     `cmd:"" help:"Scan ECS/Lambda resources and find unused ECR images that can be deleted safely."`
-	Delete   *DeleteCLI   `cmd:"" help:"Scan ECS/Lambda resources and delete unused ECR images."`
-	Version  struct{}     `cmd:"" default:"1" help:"Show version."`
+	ExtStr  map[string]string `help:"Set external string values for Jsonnet config." env:"ECRM_EXT_STR"`
+	ExtCode map[string]string `help:"Set external code values for Jsonnet config." env:"ECRM_EXT_CODE"`
+
+	Generate        *GenerateCLI        `cmd:"" help:"Generate a configuration file."`
+	Scan            *ScanCLI            `cmd:"" help:"Scan ECS/Lambda resources. Output image URIs in use."`
+	Plan            *PlanCLI            `cmd:"" help:"Scan ECS/Lambda resources and find unused ECR images that can be deleted safely."`
+	Delete          *DeleteCLI          `cmd:"" help:"Scan ECS/Lambda resources and delete unused ECR images."`
+	ImportLifecycle *ImportLifecycleCLI `cmd:"" help:"Import ECR lifecycle policies as repositories configurations."`
+	Validate        *ValidateCLI        `cmd:"" help:"Validate the configuration file."`
+	Merge           *MergeCLI           `cmd:"" help:"Merge scanned files into a scanned file."`
+	Diff            *DiffCLI            `cmd:"" help:"Show image URIs appeared or disappeared between two scanned files."`
+	Schema          *SchemaCLI          `cmd:"" help:"Output JSON Schema of the configuration file."`
+	Version         struct{}            `cmd:"" default:"1" help:"Show version."`
 
 	command string
 	app     *App
 }
 
 type GenerateCLI struct {
+	Merge           bool     `help:"Merge newly discovered resources into the existing configuration file." env:"ECRM_MERGE"`
+	Output          string   `help:"File name of the output. \"-\" is STDOUT. The default is the configuration file." short:"o" env:"ECRM_OUTPUT"`
+	Force           bool     `help:"Overwrite the output without confirmation." env:"ECRM_FORCE"`
+	Format          string   `help:"Output format (yaml, json)" default:"yaml" enum:"yaml,json" env:"ECRM_FORMAT"`
+	KeepCount       int64    `help:"keep_count of the generated entries." default:"${default_keep_count}" env:"ECRM_KEEP_COUNT"`
+	Expires         string   `help:"expires of the generated repositories." default:"${default_expires}" env:"ECRM_EXPIRES"`
+	KeepTagPatterns []string `help:"keep_tag_patterns of the generated repositories." default:"${default_keep_tag_patterns}" env:"ECRM_KEEP_TAG_PATTERNS"`
+	MinGroupSize    int      `help:"Minimum number of resources to be grouped into a name_pattern." default:"${default_min_group_size}" env:"ECRM_MIN_GROUP_SIZE"`
+	AnalyzeTags     bool     `help:"Suggest keep_tag_patterns and semver of repositories by the existing image tags." default:"true" negatable:"" env:"ECRM_ANALYZE_TAGS"`
+	Analyze         bool     `help:"Suggest expires and keep_count of repositories by the push history and images in use." env:"ECRM_ANALYZE"`
+	ScannedFiles    []string `help:"Files of the scan result. Images in these files are also analyzed as in use." env:"ECRM_SCANNED_FILES"`
 }
 
-func (c *GenerateCLI) Option() *Option {
-	return &Option{}
+func (c *GenerateCLI) Option() *GenerateOption {
+	return &GenerateOption{
+		Merge:           c.Merge,
+		OutputFile:      c.Output,
+		Force:           c.Force,
+		Format:          c.Format,
+		KeepCount:       c.KeepCount,
+		Expires:         c.Expires,
+		KeepTagPatterns: c.KeepTagPatterns,
+		MinGroupSize:    c.MinGroupSize,
+		AnalyzeTags:     c.AnalyzeTags,
+		Analyze:         c.Analyze,
+		ScannedFiles:    c.ScannedFiles,
+	}
 }
 
 type PlanCLI struct {
 	PlanOrDelete
+	CompareLifecycle bool   `help:"Compare the plan with ECR lifecycle policies of repositories." env:"ECRM_COMPARE_LIFECYCLE"`
+	LifecyclePolicy  string `help:"Compare the plan with the ECR lifecycle policy FILE instead of policies of repositories." type:"existingfile" env:"ECRM_LIFECYCLE_POLICY"`
 }
 
 func (c *PlanCLI) Option() *Option {
 	return &Option{
-		OutputFile: c.Output,
-		Format:     newOutputFormatFrom(c.Format),
-		Scan:       c.Scan,
-		Delete:     false,
-		Repository: RepositoryName(c.Repository),
+		OutputFile:          c.Output,
+		Format:              newOutputFormatFrom(c.Format),
+		Scan:                c.Scan,
+		ScannedFiles:        c.ScannedFiles,
+		MaxScanAge:          c.MaxScanAge,
+		Parallelism:         c.Parallelism,
+		KeepGoing:           c.KeepGoing,
+		SignatureOption:     c.SignatureOption(),
+		Delete:              false,
+		Repository:          RepositoryName(c.Repository),
+		CompareLifecycle:    c.CompareLifecycle || c.LifecyclePolicy != "",
+		LifecyclePolicyFile: c.LifecyclePolicy,
 	}
 }
 
 type DeleteCLI struct {
 	PlanOrDelete
-	ScannedFiles []string `help:"Files of the scan result. ecrm does not delete images in these files." env:"ECRM_SCANNED_FILES"`
-	Force        bool     `help:"force delete images without confirmation" env:"ECRM_FORCE"`
+	Force bool `help:"force delete images without confirmation" env:"ECRM_FORCE"`
 }
 
 func (c *DeleteCLI) Option() *Option {
 	return &Option{
-		OutputFile:   c.Output,
-		Format:       newOutputFormatFrom(c.Format),
-		Scan:         c.Scan,
-		ScannedFiles: c.ScannedFiles,
-		Delete:       true,
-		Force:        c.Force,
-		Repository:   RepositoryName(c.Repository),
+		OutputFile:      c.Output,
+		Format:          newOutputFormatFrom(c.Format),
+		Scan:            c.Scan,
+		ScannedFiles:    c.ScannedFiles,
+		MaxScanAge:      c.MaxScanAge,
+		Parallelism:     c.Parallelism,
+		KeepGoing:       c.KeepGoing,
+		Delete:          true,
+		SignatureOption: c.SignatureOption(),
+		Force:           c.Force,
+		Repository:      RepositoryName(c.Repository),
 	}
 }
 
 type PlanOrDelete struct {
 	OutputCLI
-	Format     string `help:"Output format of plan(table, json)" default:"table" enum:"table,json" env:"ECRM_FORMAT"`
-	Scan       bool   `help:"Scan ECS/Lambda resources that in use." default:"true" negatable:"" env:"ECRM_SCAN"`
+	Format       string   `help:"Output format of plan(table, json)" default:"table" enum:"table,json" env:"ECRM_FORMAT"`
+	Scan         bool     `help:"Scan ECS/Lambda resources that in use." default:"true" negatable:"" env:"ECRM_SCAN"`
+	ScannedFiles []string `help:"Files of the scan result (or s3://bucket/key, s3://bucket/prefix/). ecrm does not delete images in these files." env:"ECRM_SCANNED_FILES"`
+	MaxScanAge   string   `help:"Fail if any of the scanned files is older than the duration (e.g. 7d)." env:"ECRM_MAX_SCAN_AGE"`
+	Parallelism  int      `help:"Number of concurrent API calls." default:"${default_parallelism}" env:"ECRM_PARALLELISM"`
+	KeepGoing    bool     `help:"Skip repositories failed to plan or delete, and process the others. Exit with 2 if any repository failed." env:"ECRM_KEEP_GOING"`
+	VerifyCLI
 	Repository string `help:"Manage images in the repository only." short:"r" env:"ECRM_REPOSITORY"`
 }
 
@@ -111,22 +160,134 @@ type OutputCLI struct {
 	Output string `help:"File name of the output. The default is STDOUT." short:"o" default:"-" env:"ECRM_OUTPUT"`
 }
 
+type VerifyCLI struct {
+	VerifyKey           string `help:"Verify the signatures of the scanned files (FILE.sig) by the ed25519 public key FILE." type:"existingfile" env:"ECRM_VERIFY_KEY"`
+	VerifyKMSKeyID      string `help:"Verify the signatures of the scanned files (FILE.sig) by the KMS key." name:"verify-kms-key-id" env:"ECRM_VERIFY_KMS_KEY_ID"`
+	KMSSigningAlgorithm string `help:"Signing algorithm of the KMS key." name:"kms-signing-algorithm" default:"${default_kms_signing_algorithm}" env:"ECRM_KMS_SIGNING_ALGORITHM"`
+}
+
+func (c *VerifyCLI) SignatureOption() SignatureOption {
+	return SignatureOption{
+		VerifyKey:           c.VerifyKey,
+		VerifyKMSKeyID:      c.VerifyKMSKeyID,
+		KMSSigningAlgorithm: c.KMSSigningAlgorithm,
+	}
+}
+
+type SignCLI struct {
+	SignKey      string `help:"Sign the output by the ed25519 private key FILE. The signature is written to OUTPUT.sig." type:"existingfile" env:"ECRM_SIGN_KEY"`
+	SignKMSKeyID string `help:"Sign the output by the KMS key. The signature is written to OUTPUT.sig." name:"sign-kms-key-id" env:"ECRM_SIGN_KMS_KEY_ID"`
+}
+
 type ScanCLI struct {
 	OutputCLI
+	SSEKMSKeyID string `help:"KMS key ID to encrypt the output to S3 (s3://bucket/key) by SSE-KMS." name:"sse-kms-key-id" env:"ECRM_SSE_KMS_KEY_ID"`
+	SignCLI
+	KMSSigningAlgorithm string `help:"Signing algorithm of the KMS key." name:"kms-signing-algorithm" default:"${default_kms_signing_algorithm}" env:"ECRM_KMS_SIGNING_ALGORITHM"`
+	Parallelism         int    `help:"Number of concurrent API calls." default:"${default_parallelism}" env:"ECRM_PARALLELISM"`
 }
 
 func (c *ScanCLI) Option() *Option {
+	return &Option{
+		OutputFile:  c.Output,
+		Scan:        true,
+		ScanOnly:    true,
+		SSEKMSKeyID: c.SSEKMSKeyID,
+		Parallelism: c.Parallelism,
+		SignatureOption: SignatureOption{
+			SignKey:             c.SignKey,
+			SignKMSKeyID:        c.SignKMSKeyID,
+			KMSSigningAlgorithm: c.KMSSigningAlgorithm,
+		},
+	}
+}
+
+type ImportLifecycleCLI struct {
+	OutputCLI
+	Repository string `help:"Import the lifecycle policy of the repository only." short:"r" env:"ECRM_REPOSITORY"`
+}
+
+func (c *ImportLifecycleCLI) Option() *Option {
+	return &Option{
+		OutputFile: c.Output,
+		Repository: RepositoryName(c.Repository),
+	}
+}
+
+type ValidateCLI struct {
+	OutputCLI
+	Format   string `help:"Output format of the result(text, json)" default:"text" enum:"text,json" env:"ECRM_FORMAT"`
+	CheckAWS bool   `help:"Check that every entry matches at least one resource in the AWS account." name:"check-aws" env:"ECRM_CHECK_AWS"`
+	Strict   bool   `help:"Treat warnings as errors." env:"ECRM_STRICT"`
+}
+
+func (c *ValidateCLI) Option() *ValidateOption {
+	return &ValidateOption{
+		OutputFile: c.Output,
+		Format:     c.Format,
+		CheckAWS:   c.CheckAWS,
+		Strict:     c.Strict,
+	}
+}
+
+type SchemaCLI struct {
+	OutputCLI
+}
+
+func (c *SchemaCLI) Option() *Option {
 	return &Option{
 		OutputFile: c.Output,
-		Scan:       true,
-		ScanOnly:   true,
+	}
+}
+
+type MergeCLI struct {
+	Files       []string `arg:"" help:"Scanned files to merge (or s3://bucket/key, s3://bucket/prefix/)."`
+	Output      string   `help:"File name of the output (or s3://bucket/key). The default is STDOUT." short:"o" default:"-" env:"ECRM_OUTPUT"`
+	SSEKMSKeyID string   `help:"KMS key ID to encrypt the output to S3 (s3://bucket/key) by SSE-KMS." name:"sse-kms-key-id" env:"ECRM_SSE_KMS_KEY_ID"`
+	SignCLI
+	VerifyCLI
+}
+
+func (c *MergeCLI) Option() *MergeOption {
+	sig := c.VerifyCLI.SignatureOption()
+	sig.SignKey, sig.SignKMSKeyID = c.SignKey, c.SignKMSKeyID
+	return &MergeOption{
+		Files:           c.Files,
+		OutputFile:      c.Output,
+		SSEKMSKeyID:     c.SSEKMSKeyID,
+		SignatureOption: sig,
+	}
+}
+
+type DiffCLI struct {
+	OutputCLI
+	Old    string `arg:"" help:"Old scanned file (or s3://bucket/key)."`
+	New    string `arg:"" help:"New scanned file (or s3://bucket/key)."`
+	Format string `help:"Output format (text, json)" default:"text" enum:"text,json" env:"ECRM_FORMAT"`
+	VerifyCLI
+}
+
+func (c *DiffCLI) Option() *DiffOption {
+	return &DiffOption{
+		Old:             c.Old,
+		New:             c.New,
+		OutputFile:      c.Output,
+		Format:          c.Format,
+		SignatureOption: c.VerifyCLI.SignatureOption(),
 	}
 }
 
 func (app *App) NewCLI() *CLI {
 	c := &CLI{}
-	k := kong.Parse(c)
-	c.command = k.Command()
+	k := kong.Parse(c, kong.Vars{
+		"default_keep_count":            strconv.Itoa(DefaultKeepCount),
+		"default_expires":               DefaultExpiresStr,
+		"default_keep_tag_patterns":     strings.Join(DefaultKeepTagPatterns, ","),
+		"default_min_group_size":        strconv.Itoa(DefaultMinGroupSize),
+		"default_kms_signing_algorithm": DefaultKMSSigningAlgorithm,
+		"default_parallelism":           strconv.Itoa(DefaultParallelism),
+	})
+	c.command = strings.Fields(k.Command())[0] // without arguments. e.g. "diff <old> <new>"
 	c.app = app
 	return c
 }
@@ -135,16 +296,31 @@ func (c *CLI) Run(ctx context.Context) error {
 	color.NoColor = !c.Color
 	SetLogLevel(c.LogLevel)
 	log.Println("[debug] region:", c.app.region)
+	c.app.configOption = &ConfigOption{
+		ExtStr:    c.ExtStr,
+		ExtCode:   c.ExtCode,
+		AWSConfig: &c.app.awsCfg,
+	}
 
 	switch c.command {
 	case "generate":
-		return c.app.GenerateConfig(ctx, c.Config)
+		return c.app.GenerateConfig(ctx, c.Config, c.Generate.Option())
 	case "scan":
 		return c.app.Run(ctx, c.Config, c.Scan.Option())
 	case "plan":
 		return c.app.Run(ctx, c.Config, c.Plan.Option())
 	case "delete":
 		return c.app.Run(ctx, c.Config, c.Delete.Option())
+	case "validate":
+		return c.app.ValidateConfig(ctx, c.Config, c.Validate.Option())
+	case "schema":
+		return c.app.PrintSchema(ctx, c.Schema.Option())
+	case "merge":
+		return c.app.MergeScannedFiles(ctx, c.Merge.Option())
+	case "diff":
+		return c.app.DiffScannedFiles(ctx, c.Diff.Option())
+	case "import-lifecycle":
+		return c.app.ImportLifecyclePolicies(ctx, c.ImportLifecycle.Option())
 	case "version":
 		fmt.Printf("ecrm version %s\n", c.app.Version)
 		if !c.ShowVersion {
@@ -0,0 +1,107 @@
+package ecrm
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// ECRClient is the ECR API used by ecrm.
+type ECRClient interface {
+	DescribeRepositories(context.Context, *ecr.DescribeRepositoriesInput, ...func(*ecr.Options)) (*ecr.DescribeRepositoriesOutput, error)
+	DescribeImages(context.Context, *ecr.DescribeImagesInput, ...func(*ecr.Options)) (*ecr.DescribeImagesOutput, error)
+	BatchGetImage(context.Context, *ecr.BatchGetImageInput, ...func(*ecr.Options)) (*ecr.BatchGetImageOutput, error)
+	BatchDeleteImage(context.Context, *ecr.BatchDeleteImageInput, ...func(*ecr.Options)) (*ecr.BatchDeleteImageOutput, error)
+	ListTagsForResource(context.Context, *ecr.ListTagsForResourceInput, ...func(*ecr.Options)) (*ecr.ListTagsForResourceOutput, error)
+	GetLifecyclePolicy(context.Context, *ecr.GetLifecyclePolicyInput, ...func(*ecr.Options)) (*ecr.GetLifecyclePolicyOutput, error)
+}
+
+// ECSClient is the ECS API used by ecrm.
+type ECSClient interface {
+	ListClusters(context.Context, *ecs.ListClustersInput, ...func(*ecs.Options)) (*ecs.ListClustersOutput, error)
+	ListTaskDefinitionFamilies(context.Context, *ecs.ListTaskDefinitionFamiliesInput, ...func(*ecs.Options)) (*ecs.ListTaskDefinitionFamiliesOutput, error)
+	ListTaskDefinitions(context.Context, *ecs.ListTaskDefinitionsInput, ...func(*ecs.Options)) (*ecs.ListTaskDefinitionsOutput, error)
+	DescribeTaskDefinition(context.Context, *ecs.DescribeTaskDefinitionInput, ...func(*ecs.Options)) (*ecs.DescribeTaskDefinitionOutput, error)
+	ListTasks(context.Context, *ecs.ListTasksInput, ...func(*ecs.Options)) (*ecs.ListTasksOutput, error)
+	DescribeTasks(context.Context, *ecs.DescribeTasksInput, ...func(*ecs.Options)) (*ecs.DescribeTasksOutput, error)
+	ListServices(context.Context, *ecs.ListServicesInput, ...func(*ecs.Options)) (*ecs.ListServicesOutput, error)
+	DescribeServices(context.Context, *ecs.DescribeServicesInput, ...func(*ecs.Options)) (*ecs.DescribeServicesOutput, error)
+}
+
+// LambdaClient is the Lambda API used by ecrm.
+type LambdaClient interface {
+	ListFunctions(context.Context, *lambda.ListFunctionsInput, ...func(*lambda.Options)) (*lambda.ListFunctionsOutput, error)
+	ListVersionsByFunction(context.Context, *lambda.ListVersionsByFunctionInput, ...func(*lambda.Options)) (*lambda.ListVersionsByFunctionOutput, error)
+	ListAliases(context.Context, *lambda.ListAliasesInput, ...func(*lambda.Options)) (*lambda.ListAliasesOutput, error)
+	GetFunction(context.Context, *lambda.GetFunctionInput, ...func(*lambda.Options)) (*lambda.GetFunctionOutput, error)
+}
+
+// STSClient is the STS API used by ecrm.
+type STSClient interface {
+	GetCallerIdentity(context.Context, *sts.GetCallerIdentityInput, ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
+}
+
+// clients is a set of AWS API clients. nil clients are created from aws.Config.
+type clients struct {
+	ecr    ECRClient
+	ecs    ECSClient
+	lambda LambdaClient
+	sts    STSClient
+}
+
+// ClientOption injects an AWS API client instead of the client created from aws.Config.
+type ClientOption func(*clients)
+
+// WithECRClient injects the ECR client.
+func WithECRClient(c ECRClient) ClientOption {
+	return func(cs *clients) { cs.ecr = c }
+}
+
+// WithECSClient injects the ECS client.
+func WithECSClient(c ECSClient) ClientOption {
+	return func(cs *clients) { cs.ecs = c }
+}
+
+// WithLambdaClient injects the Lambda client.
+func WithLambdaClient(c LambdaClient) ClientOption {
+	return func(cs *clients) { cs.lambda = c }
+}
+
+// WithSTSClient injects the STS client.
+func WithSTSClient(c STSClient) ClientOption {
+	return func(cs *clients) { cs.sts = c }
+}
+
+func newClients(opts []ClientOption) *clients {
+	cs := &clients{}
+	for _, opt := range opts {
+		opt(cs)
+	}
+	return cs
+}
+
+// newClientsWithConfig returns the clients of opts, and creates the other clients from cfg.
+func newClientsWithConfig(cfg aws.Config, opts []ClientOption) *clients {
+	cs := newClients(opts)
+	if cs.ecr == nil {
+		cs.ecr = newECRClient(cfg)
+	}
+	if cs.ecs == nil {
+		cs.ecs = ecs.NewFromConfig(cfg, func(o *ecs.Options) {
+			o.Retryer = adaptiveRetryer()
+		})
+	}
+	if cs.lambda == nil {
+		cs.lambda = lambda.NewFromConfig(cfg, func(o *lambda.Options) {
+			o.Retryer = adaptiveRetryer()
+		})
+	}
+	if cs.sts == nil {
+		cs.sts = sts.NewFromConfig(cfg)
+	}
+	return cs
+}
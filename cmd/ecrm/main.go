@@ -28,7 +28,7 @@ func main() {
 	}
 	if err := cli.Run(ctx); err != nil {
 		log.Println("[error]", err)
-		os.Exit(1)
+		os.Exit(ecrm.ExitCode(err))
 	}
 }
 
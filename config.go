@@ -1,16 +1,15 @@
 package ecrm
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"log"
-	"os"
 	"time"
 
-	"github.com/fujiwara/ecrm/wildcard"
 	"github.com/goccy/go-yaml"
 	"github.com/k1LoW/duration"
+	"github.com/samber/lo"
 )
 
 var (
@@ -24,61 +23,183 @@ type Config struct {
 	TaskDefinitions []*TaskdefConfig    `yaml:"task_definitions"`
 	LambdaFunctions []*LambdaConfig     `yaml:"lambda_functions"`
 	Repositories    []*RepositoryConfig `yaml:"repositories"`
+
+	// RepositoryTags enables to override repositories configurations by the tags of ECR repositories.
+	RepositoryTags bool `yaml:"repository_tags,omitempty"`
+
+	// RepositoryTagsOptIn enables repositories that match no repositories entry to be managed by the ecrm:expires tag.
+	RepositoryTagsOptIn bool `yaml:"repository_tags_opt_in,omitempty"`
+
+	// MatchStrategy is a strategy to find a repository config for a repository. "first" (default) or "most_specific".
+	MatchStrategy string `yaml:"match_strategy,omitempty"`
+
+	// Defaults are default values for the entries that don't set them.
+	Defaults *DefaultsConfig `yaml:"defaults,omitempty"`
+
+	// Include is a list of config files (or glob patterns) to be merged. Paths are relative to the including file.
+	Include []string `yaml:"include,omitempty"`
+}
+
+const (
+	MatchStrategyFirst        = "first"
+	MatchStrategyMostSpecific = "most_specific"
+)
+
+// RepositoryConfig returns the repository config for the repository by the match strategy.
+//
+// "first": the first repository config that matches the repository name.
+// "most_specific": the most specific repository config. An exact name beats the longest pattern, and the longest pattern beats wildcards.
+func (c *Config) RepositoryConfig(name RepositoryName) *RepositoryConfig {
+	var matched *RepositoryConfig
+	for _, rc := range c.Repositories {
+		if !rc.MatchName(name) {
+			continue
+		}
+		if c.MatchStrategy != MatchStrategyMostSpecific {
+			return rc
+		}
+		if matched == nil || rc.nameSpec().specificity() > matched.nameSpec().specificity() {
+			matched = rc
+		}
+	}
+	return matched
+}
+
+// shadowedRepositories returns warnings about the repository configs that are never used because they are fully shadowed by others.
+func (c *Config) shadowedRepositories() []string {
+	specs := lo.Map(c.Repositories, func(rc *RepositoryConfig, _ int) nameSpec { return rc.nameSpec() })
+	return shadowedEntries("repositories", specs, c.MatchStrategy == MatchStrategyMostSpecific)
+}
+
+// shadowedEntries returns messages about the entries that are fully shadowed by earlier entries.
+// If exactOnly is true, only exactly duplicated entries are reported.
+func shadowedEntries(section string, specs []nameSpec, exactOnly bool) []string {
+	var messages []string
+	for j, b := range specs {
+		for i, a := range specs[:j] {
+			if exactOnly {
+				if a != b {
+					continue
+				}
+			} else if !a.shadows(b) {
+				continue
+			}
+			messages = append(messages, fmt.Sprintf(
+				"%s[%d] (%s) is shadowed by %s[%d] (%s), never used",
+				section, j, b, section, i, a,
+			))
+			break
+		}
+	}
+	return messages
+}
+
+// warnFunc reports a warning found by validation at the path of the config (e.g. repositories[0]).
+type warnFunc func(path, format string, args ...any)
+
+// logWarn logs the warning. The path is not logged because the message describes the entry.
+func logWarn(_, format string, args ...any) {
+	log.Printf("[warn] "+format, args...)
 }
 
 func (c *Config) Validate() error {
+	if err := c.validate(logWarn); err != nil {
+		return err
+	}
+	// lint reports them as errors
+	for _, w := range c.shadowedRepositories() {
+		logWarn("repositories", "%s", w)
+	}
+	return nil
+}
+
+func (c *Config) validate(warn warnFunc) error {
 	if c.Clusters == nil {
-		log.Println("[warn] clusters are not defined. No ECS clusters will be scanned to find images now using.")
+		warn("clusters", "clusters are not defined. No ECS clusters will be scanned to find images now using.")
 	}
-	for _, cc := range c.Clusters {
-		if err := cc.Validate(); err != nil {
+	for i, cc := range c.Clusters {
+		if err := cc.validate(fmt.Sprintf("clusters[%d]", i), warn); err != nil {
 			return err
 		}
 	}
 
 	if c.TaskDefinitions == nil {
-		log.Println("[warn] task_definitions are not defined. No task definitions will be scanned to find images now using.")
+		warn("task_definitions", "task_definitions are not defined. No task definitions will be scanned to find images now using.")
 	}
-	for _, tc := range c.TaskDefinitions {
-		if err := tc.Validate(); err != nil {
+	for i, tc := range c.TaskDefinitions {
+		if err := tc.validate(fmt.Sprintf("task_definitions[%d]", i), warn); err != nil {
 			return err
 		}
 	}
 
 	if c.LambdaFunctions == nil {
-		log.Println("[warn] lambda_functions are not defined. No Lambda functions will be scanned to find using images.")
+		warn("lambda_functions", "lambda_functions are not defined. No Lambda functions will be scanned to find using images.")
 	}
-	for _, lc := range c.LambdaFunctions {
-		if err := lc.Validate(); err != nil {
+	for i, lc := range c.LambdaFunctions {
+		if err := lc.validate(fmt.Sprintf("lambda_functions[%d]", i), warn); err != nil {
 			return err
 		}
 	}
-	for _, rc := range c.Repositories {
-		if err := rc.Validate(); err != nil {
+	for i, rc := range c.Repositories {
+		if err := rc.validate(fmt.Sprintf("repositories[%d]", i), warn); err != nil {
 			return err
 		}
 	}
+
+	if c.RepositoryTagsOptIn && !c.RepositoryTags {
+		return errors.New("repository_tags_opt_in requires repository_tags")
+	}
+	switch c.MatchStrategy {
+	case "", MatchStrategyFirst, MatchStrategyMostSpecific:
+	default:
+		return fmt.Errorf("invalid match_strategy %s. must be %s or %s", c.MatchStrategy, MatchStrategyFirst, MatchStrategyMostSpecific)
+	}
 	return nil
 }
 
 type ClusterConfig struct {
 	Name        string `yaml:"name,omitempty"`
 	NamePattern string `yaml:"name_pattern,omitempty"`
+	NameRegexp  string `yaml:"name_regexp,omitempty"`
+
+	nameRegexp *patternRegexp
 }
 
 func (c *ClusterConfig) Validate() error {
-	if c.Name == "" && c.NamePattern == "" {
-		return errors.New("cluster name or name_pattern is required")
+	return c.validate("", logWarn)
+}
+
+func (c *ClusterConfig) validate(path string, warn warnFunc) error {
+	if c.Name == "" && c.NamePattern == "" && c.NameRegexp == "" {
+		return errors.New("cluster name, name_pattern or name_regexp is required")
+	}
+	if c.Name != "" && c.NamePattern != "" && c.NameRegexp == "" {
+		// accepted for backward compatibility. the cluster matches either of them.
+		warn(path, "cluster name %s and name_pattern %s are both defined. it is deprecated and will be an error in a future release. define them in separate entries", c.Name, c.NamePattern)
+	} else if err := exclusiveNames("clusters", c.Name, c.NamePattern, c.NameRegexp); err != nil {
+		return err
+	}
+	if err := validatePattern(c.NamePattern); err != nil {
+		return fmt.Errorf("cluster name_pattern %s: %w", c.NamePattern, err)
+	}
+	if re, err := compilePatternRegexp(c.NameRegexp); err != nil {
+		return fmt.Errorf("cluster name_regexp: %w", err)
+	} else {
+		c.nameRegexp = re
 	}
 	return nil
 }
 
+func (c *ClusterConfig) nameSpec() nameSpec {
+	return nameSpec{name: c.Name, pattern: c.NamePattern, regexp: c.NameRegexp}
+}
+
 func (c *ClusterConfig) Match(name string) bool {
 	name = clusterArnToName(name)
 	if c.Name == name {
 		return true
 	}
-	return wildcard.Match(c.NamePattern, name)
+	return matchPattern(c.NamePattern, name) || c.nameRegexp.match(name)
 }
 
 type RepositoryName string
@@ -86,17 +207,35 @@ type RepositoryName string
 type RepositoryConfig struct {
 	Name            RepositoryName `yaml:"name,omitempty"`
 	NamePattern     string         `yaml:"name_pattern,omitempty"`
+	NameRegexp      string         `yaml:"name_regexp,omitempty"`
 	Expires         string         `yaml:"expires,omitempty"`
 	KeepCount       int64          `yaml:"keep_count,omitempty"`
 	KeepTagPatterns []string       `yaml:"keep_tag_patterns,omitempty"`
+	TagRegexp       string         `yaml:"tag_regexp,omitempty"`
+	Semver          *SemverConfig  `yaml:"semver,omitempty"`
 
 	expireBefore time.Time
+	nameRegexp   *patternRegexp
+	tagRegexp    *patternRegexp
+	annotations  map[string]string // comments of the fields, output by the generator
 }
 
 func (r *RepositoryConfig) Validate() error {
+	return r.validate("", logWarn)
+}
+
+func (r *RepositoryConfig) validate(path string, warn warnFunc) error {
 	now := time.Now()
-	if r.Name != "" && r.NamePattern != "" {
-		return errors.New("repositories name and name_pattern are exclusive")
+	if err := exclusiveNames("repositories", string(r.Name), r.NamePattern, r.NameRegexp); err != nil {
+		return err
+	}
+	if err := validatePattern(r.NamePattern); err != nil {
+		return fmt.Errorf("repository name_pattern %s: %w", r.NamePattern, err)
+	}
+	if re, err := compilePatternRegexp(r.NameRegexp); err != nil {
+		return fmt.Errorf("repository name_regexp: %w", err)
+	} else {
+		r.nameRegexp = re
 	}
 	if r.Expires != "" {
 		if d, err := duration.Parse(r.Expires); err != nil {
@@ -105,55 +244,72 @@ func (r *RepositoryConfig) Validate() error {
 			r.expireBefore = now.Add(-d)
 		}
 	} else {
-		return fmt.Errorf("repository %s%s expires is required", r.Name, r.NamePattern)
+		return fmt.Errorf("repository %s%s%s expires is required", r.Name, r.NamePattern, r.NameRegexp)
 	}
 
 	if len(r.KeepTagPatterns) == 0 {
-		log.Printf(
-			"[warn] keep_tag_patterns are not defined. set default keep_tag_patterns to %v",
+		warn(
+			path,
+			"keep_tag_patterns are not defined. set default keep_tag_patterns to %v",
 			DefaultKeepTagPatterns,
 		)
 		r.KeepTagPatterns = DefaultKeepTagPatterns
 	}
+	for _, pattern := range r.KeepTagPatterns {
+		if err := validatePattern(pattern); err != nil {
+			return fmt.Errorf("repository %s%s%s keep_tag_patterns %s: %w", r.Name, r.NamePattern, r.NameRegexp, pattern, err)
+		}
+	}
+	if re, err := compilePatternRegexp(r.TagRegexp); err != nil {
+		return fmt.Errorf("repository %s%s%s tag_regexp: %w", r.Name, r.NamePattern, r.NameRegexp, err)
+	} else {
+		r.tagRegexp = re
+	}
+
+	if r.Semver != nil {
+		if err := r.Semver.Validate(); err != nil {
+			return fmt.Errorf("repository %s%s%s %w", r.Name, r.NamePattern, r.NameRegexp, err)
+		}
+	}
 
 	return nil
 }
 
+func (r *RepositoryConfig) nameSpec() nameSpec {
+	return nameSpec{name: string(r.Name), pattern: r.NamePattern, regexp: r.NameRegexp}
+}
+
 func (r *RepositoryConfig) MatchName(name RepositoryName) bool {
 	if r.Name == name {
 		return true
 	}
-	return wildcard.Match(r.NamePattern, string(name))
+	return matchPattern(r.NamePattern, string(name)) || r.nameRegexp.match(string(name))
 }
 
 func (r *RepositoryConfig) MatchTag(tag string) bool {
-	for _, pattern := range r.KeepTagPatterns {
-		if wildcard.Match(pattern, tag) {
-			return true
-		}
-	}
-	return false
+	return matchPatterns(r.KeepTagPatterns, tag) || r.tagRegexp.match(tag)
 }
 
 func (r *RepositoryConfig) IsExpired(at time.Time) bool {
 	return at.Before(r.expireBefore)
 }
 
-func LoadConfig(path string) (*Config, error) {
+// LoadConfig loads the config file. The path may be a URL of s3://bucket/key or ssm://parameter-name. opt may be nil.
+func LoadConfig(ctx context.Context, path string, opt *ConfigOption) (*Config, error) {
 	log.Println("[info] loading config file:", path)
-	f, err := os.Open(path)
+	c, err := loadConfigFile(ctx, path, opt, nil)
 	if err != nil {
 		return nil, err
 	}
-	b, err := io.ReadAll(f)
-	if err != nil {
+	if err := c.Validate(); err != nil {
 		return nil, err
 	}
+	return c, nil
+}
+
+func parseConfig(b []byte, opts ...yaml.DecodeOption) (*Config, error) {
 	c := &Config{}
-	if err := yaml.Unmarshal(b, c); err != nil {
-		return nil, err
-	}
-	if err := c.Validate(); err != nil {
+	if err := yaml.UnmarshalWithOptions(b, c, opts...); err != nil {
 		return nil, err
 	}
 	return c, nil
@@ -162,19 +318,36 @@ func LoadConfig(path string) (*Config, error) {
 type TaskdefConfig struct {
 	Name        string `yaml:"name,omitempty"`
 	NamePattern string `yaml:"name_pattern,omitempty"`
+	NameRegexp  string `yaml:"name_regexp,omitempty"`
 	KeepCount   int64  `yaml:"keep_count,omitempty"`
+
+	nameRegexp *patternRegexp
 }
 
 func (c *TaskdefConfig) Validate() error {
-	if c.Name != "" && c.NamePattern != "" {
-		return errors.New("task_definitions name and name_pattern are exclusive")
+	return c.validate("", logWarn)
+}
+
+func (c *TaskdefConfig) validate(path string, warn warnFunc) error {
+	if err := exclusiveNames("task_definitions", c.Name, c.NamePattern, c.NameRegexp); err != nil {
+		return err
+	}
+	if err := validatePattern(c.NamePattern); err != nil {
+		return fmt.Errorf("task_definitions name_pattern %s: %w", c.NamePattern, err)
+	}
+	if re, err := compilePatternRegexp(c.NameRegexp); err != nil {
+		return fmt.Errorf("task_definitions name_regexp: %w", err)
+	} else {
+		c.nameRegexp = re
 	}
 
 	if c.KeepCount == 0 {
-		log.Printf(
-			"[warn] keep_count for task definition %s%s is not defined. set default keep_count to %d",
+		warn(
+			path,
+			"keep_count for task definition %s%s%s is not defined. set default keep_count to %d",
 			c.Name,
 			c.NamePattern,
+			c.NameRegexp,
 			DefaultKeepCount,
 		)
 		c.KeepCount = int64(DefaultKeepCount)
@@ -182,44 +355,70 @@ func (c *TaskdefConfig) Validate() error {
 	return nil
 }
 
+func (c *TaskdefConfig) nameSpec() nameSpec {
+	return nameSpec{name: c.Name, pattern: c.NamePattern, regexp: c.NameRegexp}
+}
+
 func (c *TaskdefConfig) Match(name string) bool {
 	if c.Name == name {
 		return true
 	}
-	return wildcard.Match(c.NamePattern, name)
+	return matchPattern(c.NamePattern, name) || c.nameRegexp.match(name)
 }
 
 type LambdaConfig struct {
 	Name        string `yaml:"name,omitempty"`
 	NamePattern string `yaml:"name_pattern,omitempty"`
+	NameRegexp  string `yaml:"name_regexp,omitempty"`
 	KeepCount   int64  `yaml:"keep_count,omitempty"`
 	KeepAliase  *bool  `yaml:"keep_aliase,omitempty"` // for backward compatibility
+
+	nameRegexp *patternRegexp
 }
 
 func (c *LambdaConfig) Validate() error {
-	if c.Name != "" && c.NamePattern != "" {
-		return errors.New("lambda_functions name and name_pattern are exclusive")
+	return c.validate("", logWarn)
+}
+
+func (c *LambdaConfig) validate(path string, warn warnFunc) error {
+	if err := exclusiveNames("lambda_functions", c.Name, c.NamePattern, c.NameRegexp); err != nil {
+		return err
+	}
+	if err := validatePattern(c.NamePattern); err != nil {
+		return fmt.Errorf("lambda_functions name_pattern %s: %w", c.NamePattern, err)
+	}
+	if re, err := compilePatternRegexp(c.NameRegexp); err != nil {
+		return fmt.Errorf("lambda_functions name_regexp: %w", err)
+	} else {
+		c.nameRegexp = re
 	}
 	if c.KeepCount == 0 {
-		log.Printf(
-			"[warn] keep_count for lambda_functions %s%s is not defined. Using default keep_count=%d",
+		warn(
+			path,
+			"keep_count for lambda_functions %s%s%s is not defined. Using default keep_count=%d",
 			c.Name,
 			c.NamePattern,
+			c.NameRegexp,
 			DefaultKeepCount,
 		)
 		c.KeepCount = int64(DefaultKeepCount)
 	}
 	if c.KeepAliase != nil {
-		log.Printf(
-			"[warn] \"keep_aliase\" is obsoleted. All aliased versions are always kept. Please remove it from the lambda_functions section.",
+		warn(
+			path,
+			"\"keep_aliase\" is obsoleted. All aliased versions are always kept. Please remove it from the lambda_functions section.",
 		)
 	}
 	return nil
 }
 
+func (c *LambdaConfig) nameSpec() nameSpec {
+	return nameSpec{name: c.Name, pattern: c.NamePattern, regexp: c.NameRegexp}
+}
+
 func (c *LambdaConfig) Match(name string) bool {
 	if c.Name == name {
 		return true
 	}
-	return wildcard.Match(c.NamePattern, name)
+	return matchPattern(c.NamePattern, name) || c.nameRegexp.match(name)
 }
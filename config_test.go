@@ -0,0 +1,391 @@
+package ecrm_test
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestLoadConfigRegexp(t *testing.T) {
+	c, err := ecrm.LoadConfig(context.Background(), "testdata/regexp.yaml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expect := range map[string]bool{
+		"prod-app": true,
+		"stg-app":  true,
+		"dev-app":  false,
+		"arn:aws:ecs:ap-northeast-1:123456789012:cluster/prod-web": true,
+	} {
+		if got := c.Clusters[0].Match(name); got != expect {
+			t.Errorf("cluster %s match %v, expected %v", name, got, expect)
+		}
+	}
+	for name, expect := range map[string]bool{
+		"app":       true,
+		"app-batch": false,
+	} {
+		if got := c.TaskDefinitions[0].Match(name); got != expect {
+			t.Errorf("task definition %s match %v, expected %v", name, got, expect)
+		}
+	}
+	for name, expect := range map[string]bool{
+		"app":      true,
+		"test-app": false,
+	} {
+		if got := c.LambdaFunctions[0].Match(name); got != expect {
+			t.Errorf("lambda function %s match %v, expected %v", name, got, expect)
+		}
+	}
+
+	rc := c.Repositories[0]
+	for name, expect := range map[ecrm.RepositoryName]bool{
+		"prod/app":   true,
+		"prod/cache": false,
+	} {
+		if got := rc.MatchName(name); got != expect {
+			t.Errorf("repository %s match %v, expected %v", name, got, expect)
+		}
+	}
+	for tag, expect := range map[string]bool{
+		"release-1":    true,
+		"release-2-rc": false,
+		"latest":       false,
+		"bdbfd1f697393756cddf7f8f5726ad80d1e26c37": true,
+		"fe668fb9": false,
+	} {
+		if got := rc.MatchTag(tag); got != expect {
+			t.Errorf("tag %s match %v, expected %v", tag, got, expect)
+		}
+	}
+}
+
+func TestLoadConfigInvalidRegexp(t *testing.T) {
+	_, err := ecrm.LoadConfig(context.Background(), "testdata/invalid_regexp.yaml", nil)
+	if err == nil {
+		t.Fatal("expected error for invalid regexp")
+	}
+	t.Log(err)
+}
+
+func TestClusterConfigNameAndPattern(t *testing.T) {
+	// name and name_pattern are deprecated but accepted for backward compatibility
+	cc := &ecrm.ClusterConfig{Name: "default", NamePattern: "prod-*"}
+	if err := cc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for name, expect := range map[string]bool{
+		"default":  true,
+		"prod-web": true,
+		"dev-web":  false,
+	} {
+		if got := cc.Match(name); got != expect {
+			t.Errorf("cluster %s match %v, expected %v", name, got, expect)
+		}
+	}
+	cc = &ecrm.ClusterConfig{Name: "default", NameRegexp: "^prod-"}
+	if err := cc.Validate(); err == nil {
+		t.Error("name and name_regexp must be exclusive")
+	}
+}
+
+func TestLoadConfigTemplate(t *testing.T) {
+	t.Setenv("ECRM_TEST_CLUSTER", "prod")
+	t.Setenv("ECRM_TEST_KEEP_COUNT", "10")
+	c, err := ecrm.LoadConfig(context.Background(), "testdata/template.yaml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Clusters[0].Name != "prod" {
+		t.Errorf("unexpected cluster name: %s", c.Clusters[0].Name)
+	}
+	if rc := c.Repositories[0]; rc.Expires != "30d" || rc.KeepCount != 10 {
+		t.Errorf("unexpected repository config: %#v", rc)
+	}
+}
+
+func TestLoadConfigTemplateMustEnv(t *testing.T) {
+	_, err := ecrm.LoadConfig(context.Background(), "testdata/template.yaml", nil)
+	if err == nil || !strings.Contains(err.Error(), "ECRM_TEST_CLUSTER") {
+		t.Errorf("expected error for undefined ECRM_TEST_CLUSTER: %v", err)
+	}
+}
+
+func TestLoadConfigJsonnet(t *testing.T) {
+	t.Setenv("ECRM_TEST_CLUSTER", "prod")
+	t.Setenv("ECRM_TEST_EXPIRES", "90d")
+	c, err := ecrm.LoadConfig(context.Background(), "testdata/config.jsonnet", &ecrm.ConfigOption{
+		ExtStr:  map[string]string{"repository": "prod/*"},
+		ExtCode: map[string]string{"keep_count": "5"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Clusters[0].Name != "prod" {
+		t.Errorf("unexpected cluster name: %s", c.Clusters[0].Name)
+	}
+	rc := c.Repositories[0]
+	if rc.NamePattern != "prod/*" || rc.Expires != "90d" || rc.KeepCount != 5 {
+		t.Errorf("unexpected repository config: %#v", rc)
+	}
+
+	if _, err := ecrm.LoadConfig(context.Background(), "testdata/config.jsonnet", nil); err == nil {
+		t.Error("expected error for undefined external variables")
+	}
+}
+
+func TestLoadConfigInclude(t *testing.T) {
+	c, err := ecrm.LoadConfig(context.Background(), "testdata/include/main.yaml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type entry struct {
+		Name            string
+		Expires         string
+		KeepCount       int64
+		KeepTagPatterns []string
+	}
+	var repos []entry
+	for _, rc := range c.Repositories {
+		repos = append(repos, entry{string(rc.Name) + rc.NamePattern, rc.Expires, rc.KeepCount, rc.KeepTagPatterns})
+	}
+	patterns := []string{"latest", "release-*"}
+	expect := []entry{
+		{"common/app", "90d", 3, patterns},
+		{"team-a/*", "30d", 10, patterns},
+		{"team-a-dev/*", "7d", 10, patterns},
+		{"team-b/*", "30d", 1, patterns},
+	}
+	if diff := cmp.Diff(expect, repos); diff != "" {
+		t.Errorf("unexpected repositories (-want +got):\n%s", diff)
+	}
+	if c.TaskDefinitions[0].KeepCount != 3 {
+		t.Errorf("unexpected task definition keep_count: %d", c.TaskDefinitions[0].KeepCount)
+	}
+	if len(c.LambdaFunctions) != 1 || c.LambdaFunctions[0].KeepCount != 2 {
+		t.Errorf("unexpected lambda functions: %v", c.LambdaFunctions)
+	}
+}
+
+func TestLoadConfigIncludeCycle(t *testing.T) {
+	_, err := ecrm.LoadConfig(context.Background(), "testdata/include/cycle_a.yaml", nil)
+	if err == nil || !strings.Contains(err.Error(), "include cycle detected") {
+		t.Errorf("expected include cycle error: %v", err)
+	}
+}
+
+var overrideByRepositoryTagsTests = []struct {
+	name       string
+	rc         *ecrm.RepositoryConfig
+	tags       map[string]string
+	optIn      bool
+	expect     *ecrm.RepositoryConfig
+	overridden []string
+	isErr      bool
+}{
+	{
+		name:   "no tags",
+		rc:     &ecrm.RepositoryConfig{NamePattern: "*", Expires: "30d", KeepCount: 3},
+		tags:   map[string]string{"Owner": "team-a"},
+		expect: &ecrm.RepositoryConfig{NamePattern: "*", Expires: "30d", KeepCount: 3},
+	},
+	{
+		name: "override",
+		rc:   &ecrm.RepositoryConfig{NamePattern: "*", Expires: "30d", KeepCount: 3, KeepTagPatterns: []string{"latest"}},
+		tags: map[string]string{"ecrm:expires": "90d", "ecrm:keep_tag_patterns": "latest stable"},
+		expect: &ecrm.RepositoryConfig{
+			Name: "foo/bar", Expires: "90d", KeepCount: 3, KeepTagPatterns: []string{"latest", "stable"},
+		},
+		overridden: []string{"expires", "keep_tag_patterns"},
+	},
+	{
+		name:  "not matched but opted in by expires tag",
+		tags:  map[string]string{"ecrm:expires": "7d", "ecrm:keep_count": "10"},
+		optIn: true,
+		expect: &ecrm.RepositoryConfig{
+			Name: "foo/bar", Expires: "7d", KeepCount: 10, KeepTagPatterns: ecrm.DefaultKeepTagPatterns,
+		},
+		overridden: []string{"expires", "keep_count"},
+	},
+	{
+		name: "not matched and opt-in disabled",
+		tags: map[string]string{"ecrm:expires": "7d", "ecrm:keep_count": "10"},
+	},
+	{
+		name:  "not matched",
+		tags:  map[string]string{"ecrm:keep_count": "10"},
+		optIn: true,
+	},
+	{
+		name: "opt-out",
+		rc:   &ecrm.RepositoryConfig{NamePattern: "*", Expires: "30d"},
+		tags: map[string]string{"ecrm:opt-out": "true", "ecrm:expires": "7d"},
+	},
+	{
+		name:  "invalid keep_count",
+		rc:    &ecrm.RepositoryConfig{NamePattern: "*", Expires: "30d"},
+		tags:  map[string]string{"ecrm:keep_count": "many"},
+		isErr: true,
+	},
+	{
+		name:  "invalid expires",
+		rc:    &ecrm.RepositoryConfig{NamePattern: "*", Expires: "30d"},
+		tags:  map[string]string{"ecrm:expires": "forever"},
+		isErr: true,
+	},
+	{
+		name:  "unknown tag",
+		rc:    &ecrm.RepositoryConfig{NamePattern: "*", Expires: "30d"},
+		tags:  map[string]string{"ecrm:expire": "7d"},
+		isErr: true,
+	},
+}
+
+func TestOverrideByRepositoryTags(t *testing.T) {
+	for _, tt := range overrideByRepositoryTagsTests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, overridden, err := ecrm.OverrideByRepositoryTags("foo/bar", tt.rc, tt.tags, tt.optIn)
+			if tt.isErr {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expect, rc, cmpopts.IgnoreUnexported(ecrm.RepositoryConfig{})); diff != "" {
+				t.Errorf("unexpected config: %s", diff)
+			}
+			if diff := cmp.Diff(tt.overridden, overridden); diff != "" {
+				t.Errorf("unexpected overridden: %s", diff)
+			}
+		})
+	}
+}
+
+func TestRepositoryConfigMostSpecific(t *testing.T) {
+	c, err := ecrm.LoadConfig(context.Background(), "testdata/most_specific.yaml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expect := range map[ecrm.RepositoryName]string{
+		"dev/app":          "30d",
+		"prod/web":         "90d",
+		"prod/app-web":     "180d",
+		"prod/app-special": "365d",
+	} {
+		if got := c.RepositoryConfig(name).Expires; got != expect {
+			t.Errorf("repository %s expires %s, expected %s", name, got, expect)
+		}
+	}
+	if w := ecrm.ShadowedRepositories(c); len(w) != 0 {
+		t.Errorf("unexpected shadowed warnings: %v", w)
+	}
+
+	// the first strategy
+	c.MatchStrategy = ecrm.MatchStrategyFirst
+	if got := c.RepositoryConfig("prod/app-special").Expires; got != "30d" {
+		t.Errorf("repository prod/app-special expires %s, expected 30d", got)
+	}
+	if w := ecrm.ShadowedRepositories(c); len(w) != 3 {
+		t.Errorf("unexpected shadowed warnings: %v", w)
+	}
+}
+
+func TestShadowedRepositories(t *testing.T) {
+	c := &ecrm.Config{
+		Repositories: []*ecrm.RepositoryConfig{
+			{NamePattern: "prod/*"},
+			{NamePattern: "prod/app-*"},
+			{NamePattern: "prod?app"},
+			{NamePattern: "dev/app-?"},
+			{NamePattern: "dev/app-*"},
+			{Name: "dev/app-1"},
+			{NameRegexp: "^stg/"},
+			{NameRegexp: "^stg/"},
+		},
+	}
+	expect := []string{
+		"repositories[1] (name_pattern:prod/app-*) is shadowed by repositories[0] (name_pattern:prod/*), never used",
+		"repositories[5] (name:dev/app-1) is shadowed by repositories[3] (name_pattern:dev/app-?), never used",
+		"repositories[7] (name_regexp:^stg/) is shadowed by repositories[6] (name_regexp:^stg/), never used",
+	}
+	if diff := cmp.Diff(expect, ecrm.ShadowedRepositories(c)); diff != "" {
+		t.Errorf("unexpected shadowed warnings: %s", diff)
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	var logs bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logs)
+	r := ecrm.LintConfig(context.Background(), "testdata/lint.yaml", nil)
+	if strings.Contains(logs.String(), "[warn]") {
+		t.Errorf("warnings must be reported only as issues, but logged:\n%s", logs.String())
+	}
+	if r.Valid {
+		t.Error("expected invalid")
+	}
+	for _, i := range r.Issues {
+		t.Log(i)
+	}
+	expect := []string{
+		"error: ",
+		"error: clusters: clusters[1] (name:prod) is shadowed by clusters[0] (name_pattern:*), never used",
+		"error: repositories: repositories[1] (name_pattern:prod/app) is shadowed by repositories[0] (name_pattern:prod/*), never used",
+		"warning: repositories[0]: expires 1d is shorter than 7 days",
+		"warning: task_definitions[0]: keep_count for task definition * is not defined. set default keep_count to 5",
+		"warning: repositories[0]: keep_tag_patterns are not defined. set default keep_tag_patterns to [latest]",
+		"warning: repositories[1]: keep_tag_patterns are not defined. set default keep_tag_patterns to [latest]",
+	}
+	if len(r.Issues) != len(expect) {
+		t.Fatalf("unexpected number of issues: %d", len(r.Issues))
+	}
+	for i, e := range expect {
+		if !strings.HasPrefix(r.Issues[i].String(), e) {
+			t.Errorf("unexpected issue %s, expected %s", r.Issues[i], e)
+		}
+	}
+	if !strings.Contains(r.Issues[0].Message, "keep_tags") {
+		t.Errorf("unknown field is not reported: %s", r.Issues[0].Message)
+	}
+
+	if r := ecrm.LintConfig(context.Background(), "ecrm.yaml", nil); !r.Valid {
+		t.Errorf("ecrm.yaml is invalid: %v", r.Issues)
+	}
+}
+
+func TestLintConfigValidateWarnings(t *testing.T) {
+	var logs bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logs)
+	r := ecrm.LintConfig(context.Background(), "testdata/lint_deprecated.yaml", nil)
+	if strings.Contains(logs.String(), "[warn]") {
+		t.Errorf("warnings must be reported only as issues, but logged:\n%s", logs.String())
+	}
+	if !r.Valid {
+		t.Error("expected valid")
+	}
+	// the warnings of Validate are reported at the paths of the entries
+	var got []string
+	for _, i := range r.Issues {
+		got = append(got, i.Level+": "+i.Path)
+	}
+	expect := []string{
+		"warning: clusters[0]",
+		"warning: lambda_functions[0]",
+	}
+	if diff := cmp.Diff(expect, got); diff != "" {
+		t.Errorf("unexpected issues (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(r.Issues[1].Message, `"keep_aliase" is obsoleted`) {
+		t.Errorf("unexpected message: %s", r.Issues[1].Message)
+	}
+}
@@ -0,0 +1,207 @@
+package ecrm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Songmu/prompter"
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// mergeConfig adds entries for the discovered resources that no entries in the current config match.
+func (g *Generator) mergeConfig(ctx context.Context, configFile string, d *discovered, opt *GenerateOption) error {
+	if isConfigURL(configFile) || isJsonnetFile(configFile) {
+		return fmt.Errorf("merge supports local YAML files only: %s", configFile)
+	}
+	src, err := os.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	current, err := LoadConfig(ctx, configFile, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load current config: %w", err)
+	}
+	merged, err := appendConfigEntries(src, d.config(current, opt))
+	if err != nil {
+		return fmt.Errorf("failed to merge %s: %w", configFile, err)
+	}
+	if bytes.Equal(src, merged) {
+		log.Printf("[notice] No new resources found. %s is up to date", configFile)
+		return nil
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(src)),
+		B:        difflib.SplitLines(string(merged)),
+		FromFile: configFile,
+		ToFile:   configFile + " (merged)",
+		Context:  3,
+	})
+	if err != nil {
+		return err
+	}
+	log.Println("[notice] Changes to", configFile)
+	os.Stderr.WriteString(diff)
+
+	output := opt.output(configFile)
+	if output == "-" {
+		_, err := os.Stdout.Write(merged)
+		return err
+	}
+	if !opt.Force && !prompter.YN(fmt.Sprintf("Write changes to %s?", output), false) {
+		return errors.New("aborted")
+	}
+	st, err := os.Stat(configFile)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(output, merged, st.Mode().Perm()); err != nil {
+		return err
+	}
+	log.Println("[notice] Saved", output)
+	return nil
+}
+
+// appendConfigEntries appends the entries of c to the end of each section of the YAML source.
+// The source is edited as text to preserve comments, ordering and formatting.
+// Sections that don't exist are appended to the end of the source.
+func appendConfigEntries(src []byte, c *Config) ([]byte, error) {
+	file, err := parser.ParseBytes(src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Docs) > 1 {
+		return nil, errors.New("multiple YAML documents are not supported")
+	}
+	var keys []*ast.MappingValueNode
+	if len(file.Docs) == 1 {
+		switch n := file.Docs[0].Body.(type) {
+		case nil:
+		case *ast.MappingNode:
+			keys = n.Values
+		case *ast.MappingValueNode:
+			keys = []*ast.MappingValueNode{n}
+		default:
+			return nil, errors.New("the config must be a mapping")
+		}
+	}
+
+	type insertion struct {
+		line int // insert after the line (1-based)
+		text string
+	}
+	var insertions []insertion
+	var appendix strings.Builder
+	lines := strings.SplitAfter(string(src), "\n")
+
+	for _, section := range []struct {
+		key     string
+		entries any
+		n       int
+	}{
+		{"clusters", c.Clusters, len(c.Clusters)},
+		{"task_definitions", c.TaskDefinitions, len(c.TaskDefinitions)},
+		{"lambda_functions", c.LambdaFunctions, len(c.LambdaFunctions)},
+		{"repositories", c.Repositories, len(c.Repositories)},
+	} {
+		if section.n == 0 {
+			continue
+		}
+		i, found := findKey(keys, section.key)
+		if !found {
+			b, err := yaml.MarshalWithOptions(yaml.MapSlice{{Key: section.key, Value: section.entries}}, yaml.IndentSequence(true),
+				yaml.WithComment(repositoryComments("$.repositories", c.Repositories)))
+			if err != nil {
+				return nil, err
+			}
+			appendix.Write(b)
+			continue
+		}
+		seq, ok := keys[i].Value.(*ast.SequenceNode)
+		if !ok || seq.IsFlowStyle {
+			return nil, fmt.Errorf("%s must be a block sequence to merge", section.key)
+		}
+		opts := []yaml.EncodeOption{yaml.IndentSequence(true)}
+		if section.key == "repositories" {
+			opts = append(opts, yaml.WithComment(repositoryComments("$", c.Repositories)))
+		}
+		b, err := yaml.MarshalWithOptions(section.entries, opts...)
+		if err != nil {
+			return nil, err
+		}
+		insertions = append(insertions, insertion{
+			line: sectionEndLine(lines, keys, i),
+			text: indentLines(string(b), seq.Start.Position.Column-1),
+		})
+	}
+
+	// insert from the bottom not to shift the line numbers
+	sort.SliceStable(insertions, func(i, j int) bool { return insertions[i].line > insertions[j].line })
+	for _, ins := range insertions {
+		if !strings.HasSuffix(lines[ins.line-1], "\n") {
+			lines[ins.line-1] += "\n"
+		}
+		lines = append(lines[:ins.line], append([]string{ins.text}, lines[ins.line:]...)...)
+	}
+	out := strings.Join(lines, "")
+	if appendix.Len() > 0 {
+		if out != "" && !strings.HasSuffix(out, "\n") {
+			out += "\n"
+		}
+		out += appendix.String()
+	}
+	return []byte(out), nil
+}
+
+func findKey(keys []*ast.MappingValueNode, key string) (int, bool) {
+	for i, mv := range keys {
+		if mv.Key.String() == key {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// sectionEndLine returns the last line (1-based) of the value of keys[i].
+// Trailing blank lines and top-level comments are excluded because they belong to the next key.
+func sectionEndLine(lines []string, keys []*ast.MappingValueNode, i int) int {
+	end := len(lines)
+	if lines[end-1] == "" { // the source ends with "\n"
+		end--
+	}
+	if i+1 < len(keys) {
+		end = keys[i+1].Key.GetToken().Position.Line - 1
+	}
+	start := keys[i].Key.GetToken().Position.Line
+	for end > start {
+		line := lines[end-1]
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			end--
+			continue
+		}
+		break
+	}
+	return end
+}
+
+// indentLines re-indents the lines to n spaces by the indentation of the first line.
+func indentLines(s string, n int) string {
+	current := len(s) - len(strings.TrimLeft(s, " "))
+	indent := strings.Repeat(" ", n)
+	lines := strings.SplitAfter(s, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = indent + line[min(current, len(line)-len(strings.TrimLeft(line, " "))):]
+		}
+	}
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,148 @@
+package ecrm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// readConfigFile reads the config file (or s3:// and ssm:// URLs) and returns it as YAML (or JSON, a subset of YAML).
+//
+// .jsonnet and .json files are evaluated as Jsonnet with the external variables.
+// Other files are rendered as a template with env and must_env functions.
+func readConfigFile(ctx context.Context, path string, opt *ConfigOption) ([]byte, error) {
+	isJsonnet := isJsonnetFile(path)
+	if u, ok := parseConfigURL(path); ok {
+		b, err := fetchConfig(ctx, u, opt)
+		if err != nil {
+			return nil, err
+		}
+		if isJsonnet {
+			return evaluateJsonnetSnippet(path, b, opt)
+		}
+		return renderTemplate(path, b)
+	}
+	if isJsonnet {
+		return evaluateJsonnet(path, opt)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return renderTemplate(path, b)
+}
+
+func isJsonnetFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonnet", ".json":
+		return true
+	}
+	return false
+}
+
+func envValue(key, defaultValue string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return defaultValue
+}
+
+func mustEnvValue(key string) (string, error) {
+	if v, ok := os.LookupEnv(key); ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("environment variable %s is not defined", key)
+}
+
+var templateFuncs = template.FuncMap{
+	"env": func(key string, defaults ...string) string {
+		return envValue(key, strings.Join(defaults, ""))
+	},
+	"must_env": mustEnvValue,
+}
+
+// renderTemplate renders the config as a text/template.
+//
+//	{{ env "NAME" "default" }} is replaced by the environment variable NAME or "default" if it is empty.
+//	{{ must_env "NAME" }} is replaced by the environment variable NAME. It fails if NAME is not defined.
+func renderTemplate(name string, b []byte) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return nil, fmt.Errorf("failed to render config template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+var jsonnetNativeFuncs = []*jsonnet.NativeFunction{
+	{
+		Name:   "env",
+		Params: ast.Identifiers{"name", "default"},
+		Func: func(args []any) (any, error) {
+			key, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("env: name must be a string: %v", args[0])
+			}
+			if v := os.Getenv(key); v != "" {
+				return v, nil
+			}
+			return args[1], nil
+		},
+	},
+	{
+		Name:   "must_env",
+		Params: ast.Identifiers{"name"},
+		Func: func(args []any) (any, error) {
+			key, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("must_env: name must be a string: %v", args[0])
+			}
+			return mustEnvValue(key)
+		},
+	},
+}
+
+func newJsonnetVM(opt *ConfigOption) *jsonnet.VM {
+	vm := jsonnet.MakeVM()
+	if opt != nil {
+		for k, v := range opt.ExtStr {
+			vm.ExtVar(k, v)
+		}
+		for k, v := range opt.ExtCode {
+			vm.ExtCode(k, v)
+		}
+	}
+	for _, f := range jsonnetNativeFuncs {
+		vm.NativeFunction(f)
+	}
+	return vm
+}
+
+// evaluateJsonnet evaluates the Jsonnet file.
+// env and must_env are available as native functions. e.g. std.native("env")("NAME", "default")
+func evaluateJsonnet(path string, opt *ConfigOption) ([]byte, error) {
+	s, err := newJsonnetVM(opt).EvaluateFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate jsonnet: %w", err)
+	}
+	return []byte(s), nil
+}
+
+// evaluateJsonnetSnippet evaluates the Jsonnet fetched from a remote source. Relative imports are not supported.
+func evaluateJsonnetSnippet(name string, b []byte, opt *ConfigOption) ([]byte, error) {
+	s, err := newJsonnetVM(opt).EvaluateAnonymousSnippet(name, string(b))
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate jsonnet: %w", err)
+	}
+	return []byte(s), nil
+}
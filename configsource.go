@@ -0,0 +1,120 @@
+package ecrm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+const (
+	configSchemeS3  = "s3"
+	configSchemeSSM = "ssm"
+)
+
+// parseConfigURL parses the config path as s3://bucket/key or ssm://parameter-name.
+// It returns false if the path is a local file.
+func parseConfigURL(p string) (*url.URL, bool) {
+	u, err := url.Parse(p)
+	if err != nil {
+		return nil, false
+	}
+	switch u.Scheme {
+	case configSchemeS3, configSchemeSSM:
+		return u, true
+	}
+	return nil, false
+}
+
+// isConfigURL reports whether the config path is a URL of S3 or SSM Parameter Store.
+func isConfigURL(p string) bool {
+	_, ok := parseConfigURL(p)
+	return ok
+}
+
+// resolveConfigURL resolves the included path relative to the config URL. Glob patterns are not supported.
+func resolveConfigURL(base *url.URL, ref string) string {
+	if base.Scheme == configSchemeSSM {
+		name := ref
+		if !strings.HasPrefix(ref, "/") {
+			name = path.Join(path.Dir(ssmParameterName(base)), ref)
+		}
+		return configSchemeSSM + "://" + name
+	}
+	u := *base
+	if strings.HasPrefix(ref, "/") {
+		u.Path = ref
+	} else {
+		u.Path = path.Join(path.Dir(base.Path), ref)
+	}
+	return u.String()
+}
+
+// fetchConfig fetches the config from S3 or SSM Parameter Store.
+func fetchConfig(ctx context.Context, u *url.URL, opt *ConfigOption) ([]byte, error) {
+	if opt == nil || opt.AWSConfig == nil {
+		return nil, fmt.Errorf("AWS config is required to load %s", u)
+	}
+	switch u.Scheme {
+	case configSchemeS3:
+		return fetchConfigFromS3(ctx, s3Client(*opt.AWSConfig), u)
+	case configSchemeSSM:
+		return fetchConfigFromSSM(ctx, ssm.NewFromConfig(*opt.AWSConfig), u)
+	default:
+		return nil, fmt.Errorf("unsupported config URL: %s", u)
+	}
+}
+
+// s3Client returns a S3 client. When a custom endpoint is set (e.g. local stand-ins), path-style addressing is used.
+func s3Client(cfg aws.Config) *s3.Client {
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = cfg.BaseEndpoint != nil
+	})
+}
+
+func fetchConfigFromS3(ctx context.Context, client *s3.Client, u *url.URL) ([]byte, error) {
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("invalid S3 URL %s. must be s3://bucket/key", u)
+	}
+	res, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", u, err)
+	}
+	defer res.Body.Close()
+	return io.ReadAll(res.Body)
+}
+
+// ssmParameterName returns the parameter name of ssm://name or ssm:///path/to/name.
+// Hierarchical names are always prefixed with "/".
+func ssmParameterName(u *url.URL) string {
+	name := u.Host + u.Path
+	if strings.Contains(name, "/") && !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+	return name
+}
+
+func fetchConfigFromSSM(ctx context.Context, client *ssm.Client, u *url.URL) ([]byte, error) {
+	name := ssmParameterName(u)
+	if name == "" {
+		return nil, fmt.Errorf("invalid SSM URL %s. must be ssm://parameter-name", u)
+	}
+	res, err := client.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get parameter %s: %w", name, err)
+	}
+	return []byte(aws.ToString(res.Parameter.Value)), nil
+}
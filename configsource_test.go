@@ -0,0 +1,122 @@
+package ecrm_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/fujiwara/ecrm"
+)
+
+var remoteConfigs = map[string]string{
+	"/my-bucket/ecrm/ecrm.yaml": `
+clusters:
+  - name: prod
+repositories:
+  - name_pattern: "prod/*"
+    expires: 90d
+include:
+  - teams.yaml
+`,
+	"/my-bucket/ecrm/teams.yaml": `
+repositories:
+  - name_pattern: "team-a/*"
+    expires: 30d
+`,
+	"/ecrm/config": `
+clusters:
+  - name: '{{ must_env "ECRM_TEST_CLUSTER" }}'
+repositories:
+  - name_pattern: "*"
+    expires: 30d
+`,
+}
+
+// newRemoteConfigServer returns a local stand-in of S3 (path-style GetObject) and SSM Parameter Store (GetParameter).
+func newRemoteConfigServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target := r.Header.Get("X-Amz-Target"); target != "" {
+			if target != "AmazonSSM.GetParameter" {
+				http.Error(w, "unexpected target "+target, http.StatusBadRequest)
+				return
+			}
+			var in struct{ Name string }
+			if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			v, ok := remoteConfigs[in.Name]
+			if !ok {
+				w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"__type": "ParameterNotFound"})
+				return
+			}
+			w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+			json.NewEncoder(w).Encode(map[string]any{
+				"Parameter": map[string]string{"Name": in.Name, "Type": "SecureString", "Value": v},
+			})
+			return
+		}
+		v, ok := remoteConfigs[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code></Error>`))
+			return
+		}
+		w.Write([]byte(v))
+	}))
+}
+
+func testRemoteConfigOption(url string) *ecrm.ConfigOption {
+	return &ecrm.ConfigOption{
+		AWSConfig: &aws.Config{
+			Region:       "us-east-1",
+			Credentials:  credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+			BaseEndpoint: aws.String(url),
+		},
+	}
+}
+
+func TestLoadConfigFromS3(t *testing.T) {
+	ts := newRemoteConfigServer(t)
+	defer ts.Close()
+	ctx := context.Background()
+
+	c, err := ecrm.LoadConfig(ctx, "s3://my-bucket/ecrm/ecrm.yaml", testRemoteConfigOption(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Repositories) != 2 || c.Repositories[1].NamePattern != "team-a/*" {
+		t.Errorf("unexpected repositories: %v", c.Repositories)
+	}
+
+	if _, err := ecrm.LoadConfig(ctx, "s3://my-bucket/ecrm/missing.yaml", testRemoteConfigOption(ts.URL)); err == nil {
+		t.Error("expected error for missing object")
+	}
+}
+
+func TestLoadConfigFromSSM(t *testing.T) {
+	ts := newRemoteConfigServer(t)
+	defer ts.Close()
+	ctx := context.Background()
+	t.Setenv("ECRM_TEST_CLUSTER", "stg")
+
+	for _, u := range []string{"ssm:///ecrm/config", "ssm://ecrm/config"} {
+		c, err := ecrm.LoadConfig(ctx, u, testRemoteConfigOption(ts.URL))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Clusters[0].Name != "stg" {
+			t.Errorf("unexpected clusters: %v", c.Clusters)
+		}
+	}
+
+	if _, err := ecrm.LoadConfig(ctx, "ssm://missing", testRemoteConfigOption(ts.URL)); err == nil {
+		t.Error("expected error for missing parameter")
+	}
+}
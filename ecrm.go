@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/Songmu/prompter"
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -24,21 +25,34 @@ var untaggedStr = "__UNTAGGED__"
 type App struct {
 	Version string
 
-	awsCfg aws.Config
-	ecr    *ecr.Client
-	region string
+	awsCfg        aws.Config
+	ecr           ECRClient
+	region        string
+	configOption  *ConfigOption
+	clientOptions []ClientOption
 }
 
-func New(ctx context.Context) (*App, error) {
+// New creates an app with the default AWS config. The clients of opts are used instead of the clients created from the config.
+func New(ctx context.Context, opts ...ClientOption) (*App, error) {
 	cfg, err := awsConfig.LoadDefaultConfig(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return &App{
-		region: cfg.Region,
-		awsCfg: cfg,
-		ecr:    ecr.NewFromConfig(cfg),
-	}, nil
+	return NewWithConfig(cfg, opts...), nil
+}
+
+// NewWithConfig creates an app with the AWS config. The clients of opts are used instead of the clients created from cfg.
+func NewWithConfig(cfg aws.Config, opts ...ClientOption) *App {
+	app := &App{
+		region:        cfg.Region,
+		awsCfg:        cfg,
+		ecr:           newClients(opts).ecr,
+		clientOptions: opts,
+	}
+	if app.ecr == nil {
+		app.ecr = newECRClient(cfg)
+	}
+	return app
 }
 
 func (app *App) Run(ctx context.Context, path string, opt *Option) error {
@@ -46,52 +60,132 @@ func (app *App) Run(ctx context.Context, path string, opt *Option) error {
 		return fmt.Errorf("invalid option: %w", err)
 	}
 
-	c, err := LoadConfig(path)
+	c, err := LoadConfig(ctx, path, app.configOption)
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	if opt.AWSConfig == nil {
+		opt.AWSConfig = &app.awsCfg
+	}
 
-	scanner := NewScanner(app.awsCfg)
-	if err := scanner.LoadFiles(opt.ScannedFiles); err != nil {
+	scanner := NewScanner(app.awsCfg, app.clientOptions...)
+	scanner.Metadata.ECRMVersion = app.Version
+	scanner.SetParallelism(opt.Parallelism)
+	if scanner.verifier, err = opt.verifier(opt.AWSConfig); err != nil {
+		return fmt.Errorf("failed to load the verification key: %w", err)
+	}
+	if err := scanner.LoadFiles(ctx, opt.ScannedFiles); err != nil {
 		return fmt.Errorf("failed to load scanned image URIs: %w", err)
 	}
+	if maxAge, _ := opt.maxScanAge(); maxAge > 0 {
+		if err := scanner.checkScanAge(maxAge, time.Now()); err != nil {
+			return fmt.Errorf("stale scanned files: %w", err)
+		}
+	}
 	if opt.Scan {
 		if err := scanner.Scan(ctx, c); err != nil {
 			return fmt.Errorf("failed to scan: %w", err)
 		}
 	}
-	log.Println("[info] total", len(scanner.Images), "image URIs in use")
+	keepImages := scanner.Images()
+	log.Println("[info] total", len(keepImages), "image URIs in use")
 	if opt.ScanOnly {
 		return ShowScanResult(scanner, opt)
 	}
 
-	planner := NewPlanner(app.awsCfg)
-	sums, candidates, err := planner.Plan(ctx, c.Repositories, scanner.Images, opt.Repository)
+	planner := NewPlanner(app.awsCfg, app.clientOptions...)
+	planner.SetParallelism(opt.Parallelism)
+	if opt.CompareLifecycle {
+		return app.compareLifecycle(ctx, planner, c, keepImages, opt)
+	}
+	// failed repositories skipped by --keep-going
+	var failed RepositoryErrors
+	sums, candidates, err := planner.Plan(ctx, c, keepImages, opt.Repository)
 	if err != nil {
-		return fmt.Errorf("failed to plan: %w", err)
+		var errs RepositoryErrors
+		if !opt.KeepGoing || !errors.As(err, &errs) {
+			return fmt.Errorf("failed to plan: %w", err)
+		}
+		failed = append(failed, errs...)
 	}
 	if err := ShowSummary(sums, opt); err != nil {
 		return fmt.Errorf("failed to show summary: %w", err)
 	}
 
-	if !opt.Delete {
-		return nil
+	if opt.Delete {
+		if err := app.deleteCandidates(ctx, candidates, opt); err != nil {
+			var errs RepositoryErrors
+			if !opt.KeepGoing || !errors.As(err, &errs) {
+				return fmt.Errorf("failed to delete images: %w", err)
+			}
+			failed = append(failed, errs...)
+		}
+	}
+	if len(failed) > 0 {
+		// the skipped repositories are reported once by the error with the causes
+		return &PartialError{Failed: failed}
+	}
+	return nil
+}
+
+// deleteCandidates deletes the images in the repositories concurrently.
+// Deletions of all repositories are confirmed before deleting any images unless forced.
+// A failure of a repository does not stop deleting the others, and RepositoryErrors is returned.
+func (app *App) deleteCandidates(ctx context.Context, candidates DeletableImageIDs, opt *Option) error {
+	names := candidates.RepositoryNames()
+	if !opt.Force {
+		for _, name := range names {
+			if n := len(candidates[name]); n > 0 && !prompter.YN(fmt.Sprintf("Do you delete %d images on %s?", n, name), false) {
+				return errors.New("aborted")
+			}
+		}
 	}
-	for _, name := range candidates.RepositoryNames() {
-		if err := app.DeleteImages(ctx, name, candidates[name], opt.Force); err != nil {
-			return fmt.Errorf("failed to delete images: %w", err)
+	errs := forEach(ctx, opt.parallelism(), len(names), func(ctx context.Context, i int) error {
+		return app.DeleteImages(ctx, names[i], candidates[names[i]], true)
+	})
+	var repoErrs RepositoryErrors
+	for i, err := range errs {
+		if err != nil {
+			repoErrs = append(repoErrs, &RepositoryError{Repository: names[i], Err: err})
 		}
 	}
+	if len(repoErrs) > 0 {
+		return repoErrs
+	}
 	return nil
 }
 
-func ShowScanResult(s *Scanner, opt *Option) error {
+func (app *App) compareLifecycle(ctx context.Context, planner *Planner, c *Config, keepImages Images, opt *Option) error {
+	var policy *LifecyclePolicy
+	if opt.LifecyclePolicyFile != "" {
+		var err error
+		if policy, err = LoadLifecyclePolicyFile(opt.LifecyclePolicyFile); err != nil {
+			return fmt.Errorf("failed to load lifecycle policy: %w", err)
+		}
+	}
+	table, err := planner.CompareLifecyclePolicy(ctx, c, keepImages, opt.Repository, policy)
+	if err != nil {
+		return fmt.Errorf("failed to compare lifecycle policy: %w", err)
+	}
 	w, err := opt.OutputWriter()
 	if err != nil {
 		return fmt.Errorf("failed to open output: %w", err)
 	}
 	defer w.Close()
+	return table.Print(w, opt.Format)
+}
+
+func ShowScanResult(s *Scanner, opt *Option) error {
+	w, err := signedOutputWriter(opt.OutputFile, opt.AWSConfig, opt.SSEKMSKeyID, &opt.SignatureOption)
+	if err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
 	if err := s.Save(w); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to save scanned image URIs: %w", err)
+	}
+	// the output to S3 is uploaded on Close
+	if err := w.Close(); err != nil {
 		return fmt.Errorf("failed to save scanned image URIs: %w", err)
 	}
 	return nil
@@ -142,9 +236,12 @@ func (app *App) DeleteImages(ctx context.Context, repo RepositoryName, ids []ecr
 	return nil
 }
 
-func (app *App) GenerateConfig(ctx context.Context, path string) error {
-	g := NewGenerator(app.awsCfg)
-	return g.GenerateConfig(ctx, path)
+func (app *App) GenerateConfig(ctx context.Context, path string, opt *GenerateOption) error {
+	if opt.output(path) == path && isConfigURL(path) {
+		return fmt.Errorf("generate does not support %s. generate to a local file and upload it", path)
+	}
+	g := NewGenerator(app.awsCfg, app.clientOptions...)
+	return g.GenerateConfig(ctx, path, opt)
 }
 
 func imageTag(d ecrTypes.ImageDetail) (string, bool) {
@@ -0,0 +1,69 @@
+package ecrm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Exit codes of ecrm.
+const (
+	ExitCodeOK      = 0
+	ExitCodeFailed  = 1 // the run failed
+	ExitCodePartial = 2 // the run completed except for the failed repositories (--keep-going)
+)
+
+// ExitCode returns the exit code for the error returned by the run.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitCodeOK
+	}
+	var partial *PartialError
+	if errors.As(err, &partial) {
+		return ExitCodePartial
+	}
+	return ExitCodeFailed
+}
+
+// RepositoryError is an error on a repository.
+type RepositoryError struct {
+	Repository RepositoryName
+	Err        error
+}
+
+func (e *RepositoryError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Repository, e.Err)
+}
+
+func (e *RepositoryError) Unwrap() error {
+	return e.Err
+}
+
+// RepositoryErrors is errors on repositories processed concurrently. The other repositories are processed regardless of them.
+type RepositoryErrors []*RepositoryError
+
+func (e RepositoryErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d repositories failed: %s", len(e), strings.Join(msgs, ", "))
+}
+
+// PartialError is an error of a run that skipped the failed repositories by --keep-going.
+// The other repositories are planned (and deleted) successfully.
+type PartialError struct {
+	Failed RepositoryErrors
+}
+
+func (e *PartialError) Error() string {
+	msgs := make([]string, 0, len(e.Failed))
+	for _, err := range e.Failed {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("partially failed: %d repositories are skipped: %s", len(e.Failed), strings.Join(msgs, ", "))
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Failed
+}
@@ -0,0 +1,54 @@
+package ecrm_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/fujiwara/ecrm"
+)
+
+func TestRepositoryErrors(t *testing.T) {
+	errFailed := errors.New("access denied")
+	var err error = ecrm.RepositoryErrors{
+		{Repository: "prod/app", Err: errFailed},
+		{Repository: "prod/worker", Err: errors.New("throttled")},
+	}
+	if got, want := err.Error(), "2 repositories failed: prod/app: access denied, prod/worker: throttled"; got != want {
+		t.Errorf("unexpected message: %s", got)
+	}
+	var repoErrs ecrm.RepositoryErrors
+	if !errors.As(fmt.Errorf("failed to plan: %w", err), &repoErrs) || len(repoErrs) != 2 {
+		t.Errorf("RepositoryErrors must be unwrapped: %v", repoErrs)
+	}
+	if !errors.Is(repoErrs[0], errFailed) {
+		t.Error("RepositoryError must unwrap the cause")
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	partial := &ecrm.PartialError{Failed: ecrm.RepositoryErrors{
+		{Repository: "prod/app", Err: errors.New("access denied")},
+		{Repository: "prod/worker", Err: errors.New("throttled")},
+	}}
+	if got, want := partial.Error(), "partially failed: 2 repositories are skipped: prod/app: access denied, prod/worker: throttled"; got != want {
+		t.Errorf("unexpected message: %s", got)
+	}
+	for _, tc := range []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "ok", err: nil, want: ecrm.ExitCodeOK},
+		{name: "failed", err: errors.New("failed to scan"), want: ecrm.ExitCodeFailed},
+		{name: "failed repositories without keep-going", err: fmt.Errorf("failed to plan: %w", partial.Failed), want: ecrm.ExitCodeFailed},
+		{name: "partial", err: partial, want: ecrm.ExitCodePartial},
+		{name: "wrapped partial", err: fmt.Errorf("run: %w", partial), want: ecrm.ExitCodePartial},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ecrm.ExitCode(tc.err); got != tc.want {
+				t.Errorf("unexpected exit code: %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
@@ -1,5 +1,125 @@
 package ecrm
 
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
 var (
 	ParseTaskdefArn = parseTaskdefArn
 )
+
+func SemverKeepTags(s *SemverConfig, tags []string) []string {
+	return s.keepTags(tags).members()
+}
+
+func LifecycleExpiredImages(p *LifecyclePolicy, images []ecrTypes.ImageDetail, now time.Time) map[string]int {
+	return p.expiredImages(images, now)
+}
+
+func LifecycleToRepositoryConfig(p *LifecyclePolicy, name RepositoryName) (*RepositoryConfig, []string) {
+	return p.toRepositoryConfig(name)
+}
+
+var OverrideByRepositoryTags = overrideByRepositoryTags
+
+func ShadowedRepositories(c *Config) []string {
+	return c.shadowedRepositories()
+}
+
+var DurationPattern = durationPattern
+
+var AppendConfigEntries = appendConfigEntries
+
+func DiscoveredConfig(current *Config, opt *GenerateOption, clusters, taskdefs, lambdas, repos []string) *Config {
+	d := &discovered{
+		clusters:        clusters,
+		taskDefinitions: taskdefs,
+		lambdaFunctions: lambdas,
+		repositories:    repos,
+	}
+	return d.config(current, opt)
+}
+
+var MarshalConfig = marshalConfig
+
+var InferPatterns = inferPatterns
+
+var SuggestTagRetention = suggestTagRetention
+
+func DiscoveredRepositoriesConfig(opt *GenerateOption, tags map[string][]string) *Config {
+	d := &discovered{tags: tags}
+	for name := range tags {
+		d.repositories = append(d.repositories, name)
+	}
+	return d.config(nil, opt)
+}
+
+type RetentionAnalysis = retentionAnalysis
+
+func NewRetentionAnalysis(images, taggedImages, inUse int, oldestInUse time.Time, oldestInUseRank int64) *RetentionAnalysis {
+	return &retentionAnalysis{
+		images:          images,
+		taggedImages:    taggedImages,
+		inUse:           inUse,
+		oldestInUse:     oldestInUse,
+		oldestInUseRank: oldestInUseRank,
+		rankedImages:    taggedImages,
+	}
+}
+
+func DiscoveredRetentionConfig(current *Config, opt *GenerateOption, retention map[string]*RetentionAnalysis) *Config {
+	d := &discovered{retention: retention}
+	for name := range retention {
+		d.repositories = append(d.repositories, name)
+	}
+	return d.config(current, opt)
+}
+
+func CheckScanAge(files []string, maxAge time.Duration, now time.Time) error {
+	s := NewScanner(aws.Config{})
+	if err := s.LoadFiles(context.Background(), files); err != nil {
+		return err
+	}
+	return s.checkScanAge(maxAge, now)
+}
+
+var DiffImages = diffImages
+
+func MergeScanMetadata(files []string) (ScanMetadata, error) {
+	s := NewScanner(aws.Config{})
+	if err := s.LoadFiles(context.Background(), files); err != nil {
+		return ScanMetadata{}, err
+	}
+	return mergeScanMetadata(s.files), nil
+}
+
+func SetScannerVerifier(s *Scanner, opt *SignatureOption, cfg *aws.Config) error {
+	v, err := opt.verifier(cfg)
+	if err != nil {
+		return err
+	}
+	s.verifier = v
+	return nil
+}
+
+var Parallel = parallel
+
+var ForEach = forEach
+
+var (
+	FormatTable = formatTable
+	FormatJSON  = formatJSON
+)
+
+func AnalyzeRepository(ctx context.Context, p *Planner, name RepositoryName, keepImages Images) (*RetentionAnalysis, error) {
+	return p.analyzeRepository(ctx, name, keepImages)
+}
+
+// Counts returns the number of the images, the tagged images, the images in use and the rank of the oldest image in use.
+func (a *RetentionAnalysis) Counts() (int, int, int, int64) {
+	return a.images, a.taggedImages, a.inUse, a.oldestInUseRank
+}
@@ -0,0 +1,568 @@
+package ecrm_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdaTypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/fujiwara/ecrm"
+)
+
+const (
+	fakeAccountID = "012345678901"
+	fakeRegion    = "us-east-1"
+)
+
+// fakeAWS is an in-memory fake of the ECR, ECS, Lambda and STS APIs used by ecrm.
+// It returns every result in a single page.
+type fakeAWS struct {
+	mu sync.Mutex
+
+	repositories map[string]*fakeRepository
+	clusters     map[string]*fakeCluster // by name
+	taskdefs     map[string]ecsTypes.TaskDefinition
+	functions    map[string]*fakeFunction
+
+	// failures are errors returned by the API calls. The key is "Operation" or "Operation:resource name".
+	failures map[string]error
+	// deleted is the digests deleted by BatchDeleteImage. The key is the repository name.
+	deleted map[string][]string
+}
+
+type fakeRepository struct {
+	tags      map[string]string
+	images    []fakeImage
+	lifecycle string
+}
+
+type fakeImage struct {
+	detail   ecrTypes.ImageDetail
+	manifest string
+}
+
+type fakeCluster struct {
+	tasks    []ecsTypes.Task
+	services []ecsTypes.Service
+}
+
+type fakeFunction struct {
+	versions map[string]string // version to image URI
+	aliases  map[string]string // alias name to version
+}
+
+var (
+	_ ecrm.ECRClient    = (*fakeAWS)(nil)
+	_ ecrm.ECSClient    = (*fakeAWS)(nil)
+	_ ecrm.LambdaClient = (*fakeAWS)(nil)
+	_ ecrm.STSClient    = (*fakeAWS)(nil)
+)
+
+func newFakeAWS() *fakeAWS {
+	return &fakeAWS{
+		repositories: map[string]*fakeRepository{},
+		clusters:     map[string]*fakeCluster{},
+		taskdefs:     map[string]ecsTypes.TaskDefinition{},
+		functions:    map[string]*fakeFunction{},
+		failures:     map[string]error{},
+		deleted:      map[string][]string{},
+	}
+}
+
+// clientOptions returns the options to inject the fake as every client.
+func (f *fakeAWS) clientOptions() []ecrm.ClientOption {
+	return []ecrm.ClientOption{
+		ecrm.WithECRClient(f),
+		ecrm.WithECSClient(f),
+		ecrm.WithLambdaClient(f),
+		ecrm.WithSTSClient(f),
+	}
+}
+
+func (f *fakeAWS) failure(op string, resource string) error {
+	if err, ok := f.failures[op]; ok {
+		return err
+	}
+	return f.failures[op+":"+resource]
+}
+
+func fakeDigest(name string) string {
+	return fmt.Sprintf("sha256:%064x", []byte(name))
+}
+
+func fakeImageURI(repo, ref string) ecrm.ImageURI {
+	sep := ":"
+	if strings.HasPrefix(ref, "sha256:") {
+		sep = "@"
+	}
+	return ecrm.ImageURI(fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com/%s%s%s", fakeAccountID, fakeRegion, repo, sep, ref))
+}
+
+// addImage adds a container image pushed at the time with the tags. The digest is derived from the name.
+func (f *fakeAWS) addImage(repo, name string, pushedAt time.Time, tags ...string) string {
+	r, ok := f.repositories[repo]
+	if !ok {
+		r = &fakeRepository{}
+		f.repositories[repo] = r
+	}
+	digest := fakeDigest(repo + "/" + name)
+	r.images = append(r.images, fakeImage{
+		detail: ecrTypes.ImageDetail{
+			RegistryId:             aws.String(fakeAccountID),
+			RepositoryName:         aws.String(repo),
+			ImageDigest:            aws.String(digest),
+			ImageTags:              tags,
+			ImagePushedAt:          aws.Time(pushedAt),
+			ImageSizeInBytes:       aws.Int64(1024 * 1024),
+			ImageManifestMediaType: aws.String("application/vnd.oci.image.manifest.v1+json"),
+			ArtifactMediaType:      aws.String("application/vnd.oci.image.config.v1+json"),
+		},
+		manifest: `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`,
+	})
+	return digest
+}
+
+// addImageIndex adds a multi-arch image index of the child images pushed at the time with the tags.
+func (f *fakeAWS) addImageIndex(repo, name string, pushedAt time.Time, children []string, tags ...string) string {
+	digest := f.addImage(repo, name, pushedAt, tags...)
+	r := f.repositories[repo]
+	img := &r.images[len(r.images)-1]
+	img.detail.ArtifactMediaType = nil
+	img.detail.ImageManifestMediaType = aws.String("application/vnd.oci.image.index.v1+json")
+	manifests := make([]string, 0, len(children))
+	for _, child := range children {
+		manifests = append(manifests, fmt.Sprintf(`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":%q,"size":1024}`, child))
+	}
+	img.manifest = fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[%s]}`, strings.Join(manifests, ","))
+	return digest
+}
+
+// addTaskdef adds a task definition revision using the images.
+func (f *fakeAWS) addTaskdef(family string, revision int, images ...ecrm.ImageURI) string {
+	a := fmt.Sprintf("arn:aws:ecs:%s:%s:task-definition/%s:%d", fakeRegion, fakeAccountID, family, revision)
+	td := ecsTypes.TaskDefinition{
+		TaskDefinitionArn: aws.String(a),
+		Family:            aws.String(family),
+		Revision:          int32(revision),
+	}
+	for i, u := range images {
+		td.ContainerDefinitions = append(td.ContainerDefinitions, ecsTypes.ContainerDefinition{
+			Name:  aws.String(fmt.Sprintf("container-%d", i)),
+			Image: aws.String(u.String()),
+		})
+	}
+	f.taskdefs[a] = td
+	return a
+}
+
+// addTask adds a running task of the task definition in the cluster. The image digest is resolved by the repository.
+func (f *fakeAWS) addTask(cluster, id, taskdefArn string) {
+	c := f.cluster(cluster)
+	task := ecsTypes.Task{
+		TaskArn:           aws.String(fmt.Sprintf("arn:aws:ecs:%s:%s:task/%s/%s", fakeRegion, fakeAccountID, cluster, id)),
+		TaskDefinitionArn: aws.String(taskdefArn),
+		DesiredStatus:     aws.String(string(ecsTypes.DesiredStatusRunning)),
+	}
+	for _, cd := range f.taskdefs[taskdefArn].ContainerDefinitions {
+		container := ecsTypes.Container{Name: cd.Name, Image: cd.Image}
+		u := ecrm.ImageURI(aws.ToString(cd.Image))
+		if r, ok := f.repositories[strings.SplitN(u.Short(), ":", 2)[0]]; ok {
+			for _, img := range r.images {
+				for _, tag := range img.detail.ImageTags {
+					if tag == u.Tag() {
+						container.ImageDigest = img.detail.ImageDigest
+					}
+				}
+			}
+		}
+		task.Containers = append(task.Containers, container)
+	}
+	c.tasks = append(c.tasks, task)
+}
+
+// addService adds a service deploying the task definition in the cluster.
+func (f *fakeAWS) addService(cluster, name, taskdefArn string) {
+	c := f.cluster(cluster)
+	c.services = append(c.services, ecsTypes.Service{
+		ServiceArn:  aws.String(fmt.Sprintf("arn:aws:ecs:%s:%s:service/%s/%s", fakeRegion, fakeAccountID, cluster, name)),
+		ServiceName: aws.String(name),
+		Deployments: []ecsTypes.Deployment{
+			{TaskDefinition: aws.String(taskdefArn), Status: aws.String("PRIMARY")},
+		},
+	})
+}
+
+func (f *fakeAWS) cluster(name string) *fakeCluster {
+	c, ok := f.clusters[name]
+	if !ok {
+		c = &fakeCluster{}
+		f.clusters[name] = c
+	}
+	return c
+}
+
+// addFunctionVersion adds a version of the Lambda function using the image. aliases point to the version.
+func (f *fakeAWS) addFunctionVersion(name, version string, image ecrm.ImageURI, aliases ...string) {
+	fn, ok := f.functions[name]
+	if !ok {
+		fn = &fakeFunction{versions: map[string]string{}, aliases: map[string]string{}}
+		f.functions[name] = fn
+	}
+	fn.versions[version] = image.String()
+	for _, a := range aliases {
+		fn.aliases[a] = version
+	}
+}
+
+func functionArn(name string) string {
+	return fmt.Sprintf("arn:aws:lambda:%s:%s:function:%s", fakeRegion, fakeAccountID, name)
+}
+
+// ECR
+
+func (f *fakeAWS) DescribeRepositories(_ context.Context, in *ecr.DescribeRepositoriesInput, _ ...func(*ecr.Options)) (*ecr.DescribeRepositoriesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failure("DescribeRepositories", ""); err != nil {
+		return nil, err
+	}
+	names := in.RepositoryNames
+	if len(names) == 0 {
+		for name := range f.repositories {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	out := &ecr.DescribeRepositoriesOutput{}
+	for _, name := range names {
+		if _, ok := f.repositories[name]; !ok {
+			return nil, &ecrTypes.RepositoryNotFoundException{Message: aws.String(name)}
+		}
+		out.Repositories = append(out.Repositories, ecrTypes.Repository{
+			RepositoryName: aws.String(name),
+			RepositoryArn:  aws.String(fmt.Sprintf("arn:aws:ecr:%s:%s:repository/%s", fakeRegion, fakeAccountID, name)),
+			RegistryId:     aws.String(fakeAccountID),
+		})
+	}
+	return out, nil
+}
+
+func (f *fakeAWS) DescribeImages(_ context.Context, in *ecr.DescribeImagesInput, _ ...func(*ecr.Options)) (*ecr.DescribeImagesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := aws.ToString(in.RepositoryName)
+	if err := f.failure("DescribeImages", name); err != nil {
+		return nil, err
+	}
+	r, ok := f.repositories[name]
+	if !ok {
+		return nil, &ecrTypes.RepositoryNotFoundException{Message: aws.String(name)}
+	}
+	out := &ecr.DescribeImagesOutput{}
+	for _, img := range r.images {
+		out.ImageDetails = append(out.ImageDetails, img.detail)
+	}
+	return out, nil
+}
+
+func (f *fakeAWS) BatchGetImage(_ context.Context, in *ecr.BatchGetImageInput, _ ...func(*ecr.Options)) (*ecr.BatchGetImageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := aws.ToString(in.RepositoryName)
+	if err := f.failure("BatchGetImage", name); err != nil {
+		return nil, err
+	}
+	r, ok := f.repositories[name]
+	if !ok {
+		return nil, &ecrTypes.RepositoryNotFoundException{Message: aws.String(name)}
+	}
+	out := &ecr.BatchGetImageOutput{}
+ID:
+	for i, id := range in.ImageIds {
+		for _, img := range r.images {
+			if aws.ToString(id.ImageDigest) == aws.ToString(img.detail.ImageDigest) || (id.ImageTag != nil && contains(img.detail.ImageTags, *id.ImageTag)) {
+				out.Images = append(out.Images, ecrTypes.Image{
+					RepositoryName: in.RepositoryName,
+					ImageId:        &in.ImageIds[i],
+					ImageManifest:  aws.String(img.manifest),
+				})
+				continue ID
+			}
+		}
+		out.Failures = append(out.Failures, ecrTypes.ImageFailure{ImageId: &in.ImageIds[i], FailureCode: ecrTypes.ImageFailureCodeImageNotFound})
+	}
+	return out, nil
+}
+
+func (f *fakeAWS) BatchDeleteImage(_ context.Context, in *ecr.BatchDeleteImageInput, _ ...func(*ecr.Options)) (*ecr.BatchDeleteImageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := aws.ToString(in.RepositoryName)
+	if err := f.failure("BatchDeleteImage", name); err != nil {
+		return nil, err
+	}
+	r, ok := f.repositories[name]
+	if !ok {
+		return nil, &ecrTypes.RepositoryNotFoundException{Message: aws.String(name)}
+	}
+	out := &ecr.BatchDeleteImageOutput{}
+	for _, id := range in.ImageIds {
+		digest := aws.ToString(id.ImageDigest)
+		for i, img := range r.images {
+			if aws.ToString(img.detail.ImageDigest) == digest {
+				r.images = append(r.images[:i], r.images[i+1:]...)
+				f.deleted[name] = append(f.deleted[name], digest)
+				out.ImageIds = append(out.ImageIds, id)
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeAWS) ListTagsForResource(_ context.Context, in *ecr.ListTagsForResourceInput, _ ...func(*ecr.Options)) (*ecr.ListTagsForResourceOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := strings.SplitN(aws.ToString(in.ResourceArn), ":repository/", 2)[1]
+	if err := f.failure("ListTagsForResource", name); err != nil {
+		return nil, err
+	}
+	out := &ecr.ListTagsForResourceOutput{}
+	for k, v := range f.repositories[name].tags {
+		out.Tags = append(out.Tags, ecrTypes.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	return out, nil
+}
+
+func (f *fakeAWS) GetLifecyclePolicy(_ context.Context, in *ecr.GetLifecyclePolicyInput, _ ...func(*ecr.Options)) (*ecr.GetLifecyclePolicyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := aws.ToString(in.RepositoryName)
+	r, ok := f.repositories[name]
+	if !ok || r.lifecycle == "" {
+		return nil, &ecrTypes.LifecyclePolicyNotFoundException{Message: aws.String(name)}
+	}
+	return &ecr.GetLifecyclePolicyOutput{RepositoryName: in.RepositoryName, LifecyclePolicyText: aws.String(r.lifecycle)}, nil
+}
+
+// ECS
+
+func (f *fakeAWS) ListClusters(_ context.Context, _ *ecs.ListClustersInput, _ ...func(*ecs.Options)) (*ecs.ListClustersOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failure("ListClusters", ""); err != nil {
+		return nil, err
+	}
+	out := &ecs.ListClustersOutput{}
+	for name := range f.clusters {
+		out.ClusterArns = append(out.ClusterArns, fmt.Sprintf("arn:aws:ecs:%s:%s:cluster/%s", fakeRegion, fakeAccountID, name))
+	}
+	sort.Strings(out.ClusterArns)
+	return out, nil
+}
+
+func (f *fakeAWS) ListTaskDefinitionFamilies(_ context.Context, _ *ecs.ListTaskDefinitionFamiliesInput, _ ...func(*ecs.Options)) (*ecs.ListTaskDefinitionFamiliesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	families := map[string]struct{}{}
+	for _, td := range f.taskdefs {
+		families[aws.ToString(td.Family)] = struct{}{}
+	}
+	out := &ecs.ListTaskDefinitionFamiliesOutput{}
+	for family := range families {
+		out.Families = append(out.Families, family)
+	}
+	sort.Strings(out.Families)
+	return out, nil
+}
+
+func (f *fakeAWS) ListTaskDefinitions(_ context.Context, in *ecs.ListTaskDefinitionsInput, _ ...func(*ecs.Options)) (*ecs.ListTaskDefinitionsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var tds []ecsTypes.TaskDefinition
+	for _, td := range f.taskdefs {
+		if aws.ToString(td.Family) == aws.ToString(in.FamilyPrefix) {
+			tds = append(tds, td)
+		}
+	}
+	sort.Slice(tds, func(i, j int) bool {
+		if in.Sort == ecsTypes.SortOrderDesc {
+			return tds[i].Revision > tds[j].Revision
+		}
+		return tds[i].Revision < tds[j].Revision
+	})
+	out := &ecs.ListTaskDefinitionsOutput{}
+	for i, td := range tds {
+		if in.MaxResults != nil && i >= int(*in.MaxResults) {
+			break
+		}
+		out.TaskDefinitionArns = append(out.TaskDefinitionArns, aws.ToString(td.TaskDefinitionArn))
+	}
+	return out, nil
+}
+
+func (f *fakeAWS) DescribeTaskDefinition(_ context.Context, in *ecs.DescribeTaskDefinitionInput, _ ...func(*ecs.Options)) (*ecs.DescribeTaskDefinitionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := aws.ToString(in.TaskDefinition)
+	if err := f.failure("DescribeTaskDefinition", name); err != nil {
+		return nil, err
+	}
+	for a, td := range f.taskdefs {
+		if a == name || aws.ToString(td.Family)+":"+strconv.Itoa(int(td.Revision)) == name {
+			return &ecs.DescribeTaskDefinitionOutput{TaskDefinition: &td}, nil
+		}
+	}
+	return nil, &ecsTypes.ClientException{Message: aws.String("Unable to describe task definition " + name)}
+}
+
+func (f *fakeAWS) ListTasks(_ context.Context, in *ecs.ListTasksInput, _ ...func(*ecs.Options)) (*ecs.ListTasksOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &ecs.ListTasksOutput{}
+	for _, task := range f.clusterOf(aws.ToString(in.Cluster)).tasks {
+		if aws.ToString(task.DesiredStatus) == string(in.DesiredStatus) {
+			out.TaskArns = append(out.TaskArns, aws.ToString(task.TaskArn))
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeAWS) DescribeTasks(_ context.Context, in *ecs.DescribeTasksInput, _ ...func(*ecs.Options)) (*ecs.DescribeTasksOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &ecs.DescribeTasksOutput{}
+	for _, task := range f.clusterOf(aws.ToString(in.Cluster)).tasks {
+		if contains(in.Tasks, aws.ToString(task.TaskArn)) {
+			out.Tasks = append(out.Tasks, task)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeAWS) ListServices(_ context.Context, in *ecs.ListServicesInput, _ ...func(*ecs.Options)) (*ecs.ListServicesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &ecs.ListServicesOutput{}
+	for _, sv := range f.clusterOf(aws.ToString(in.Cluster)).services {
+		out.ServiceArns = append(out.ServiceArns, aws.ToString(sv.ServiceArn))
+	}
+	return out, nil
+}
+
+func (f *fakeAWS) DescribeServices(_ context.Context, in *ecs.DescribeServicesInput, _ ...func(*ecs.Options)) (*ecs.DescribeServicesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &ecs.DescribeServicesOutput{}
+	for _, sv := range f.clusterOf(aws.ToString(in.Cluster)).services {
+		if contains(in.Services, aws.ToString(sv.ServiceArn)) {
+			out.Services = append(out.Services, sv)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeAWS) clusterOf(clusterArn string) *fakeCluster {
+	name := clusterArn[strings.LastIndex(clusterArn, "/")+1:]
+	if c, ok := f.clusters[name]; ok {
+		return c
+	}
+	return &fakeCluster{}
+}
+
+// Lambda
+
+func (f *fakeAWS) ListFunctions(_ context.Context, _ *lambda.ListFunctionsInput, _ ...func(*lambda.Options)) (*lambda.ListFunctionsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failure("ListFunctions", ""); err != nil {
+		return nil, err
+	}
+	out := &lambda.ListFunctionsOutput{}
+	for name := range f.functions {
+		out.Functions = append(out.Functions, lambdaTypes.FunctionConfiguration{
+			FunctionName: aws.String(name),
+			FunctionArn:  aws.String(functionArn(name)),
+			PackageType:  lambdaTypes.PackageTypeImage,
+		})
+	}
+	sort.Slice(out.Functions, func(i, j int) bool {
+		return *out.Functions[i].FunctionName < *out.Functions[j].FunctionName
+	})
+	return out, nil
+}
+
+func (f *fakeAWS) ListVersionsByFunction(_ context.Context, in *lambda.ListVersionsByFunctionInput, _ ...func(*lambda.Options)) (*lambda.ListVersionsByFunctionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := aws.ToString(in.FunctionName)
+	out := &lambda.ListVersionsByFunctionOutput{}
+	for v := range f.functions[name].versions {
+		out.Versions = append(out.Versions, lambdaTypes.FunctionConfiguration{
+			FunctionName: aws.String(name),
+			FunctionArn:  aws.String(functionArn(name) + ":" + v),
+			Version:      aws.String(v),
+			PackageType:  lambdaTypes.PackageTypeImage,
+		})
+	}
+	return out, nil
+}
+
+func (f *fakeAWS) ListAliases(_ context.Context, in *lambda.ListAliasesInput, _ ...func(*lambda.Options)) (*lambda.ListAliasesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := aws.ToString(in.FunctionName)
+	out := &lambda.ListAliasesOutput{}
+	for a, v := range f.functions[name].aliases {
+		out.Aliases = append(out.Aliases, lambdaTypes.AliasConfiguration{
+			Name:            aws.String(a),
+			FunctionVersion: aws.String(v),
+			AliasArn:        aws.String(functionArn(name) + ":" + a),
+		})
+	}
+	return out, nil
+}
+
+func (f *fakeAWS) GetFunction(_ context.Context, in *lambda.GetFunctionInput, _ ...func(*lambda.Options)) (*lambda.GetFunctionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// arn:aws:lambda:region:account:function:name:version
+	p := strings.Split(aws.ToString(in.FunctionName), ":")
+	name, version := p[6], p[7]
+	if err := f.failure("GetFunction", name); err != nil {
+		return nil, err
+	}
+	fn, ok := f.functions[name]
+	if !ok {
+		return nil, &lambdaTypes.ResourceNotFoundException{Message: aws.String(name)}
+	}
+	return &lambda.GetFunctionOutput{
+		Code: &lambdaTypes.FunctionCodeLocation{ImageUri: aws.String(fn.versions[version])},
+	}, nil
+}
+
+// STS
+
+func (f *fakeAWS) GetCallerIdentity(_ context.Context, _ *sts.GetCallerIdentityInput, _ ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	return &sts.GetCallerIdentityOutput{Account: aws.String(fakeAccountID)}, nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -3,89 +3,175 @@ package ecrm
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"os"
-	"regexp"
-	"sort"
 	"strings"
+	"time"
 
 	"github.com/Songmu/prompter"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/ecr"
-	"github.com/aws/aws-sdk-go-v2/service/ecs"
-	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/fujiwara/ecrm/wildcard"
 	"github.com/goccy/go-yaml"
+	"github.com/samber/lo"
 )
 
-var nameToPatternRe = regexp.MustCompile(`^.*?[/_-]`)
-
-func nameToPattern(s string) string {
-	p := nameToPatternRe.FindAllString(s, 1)
-	if len(p) > 0 {
-		return p[0] + "*"
-	}
-	return s
-}
-
 type Generator struct {
-	awsCfg aws.Config
+	awsCfg        aws.Config
+	clientOptions []ClientOption
 }
 
-func NewGenerator(cfg aws.Config) *Generator {
+// NewGenerator creates a generator. The clients of opts are used instead of the clients created from cfg.
+func NewGenerator(cfg aws.Config, opts ...ClientOption) *Generator {
 	return &Generator{
-		awsCfg: cfg,
+		awsCfg:        cfg,
+		clientOptions: opts,
 	}
 }
 
-func (g *Generator) GenerateConfig(ctx context.Context, configFile string) error {
-	config := Config{}
-	if err := g.generateClusterConfig(ctx, &config); err != nil {
-		return err
+func (g *Generator) GenerateConfig(ctx context.Context, configFile string, opt *GenerateOption) error {
+	if err := opt.Validate(); err != nil {
+		return fmt.Errorf("invalid option: %w", err)
 	}
-	if err := g.generateTaskdefConfig(ctx, &config); err != nil {
+	d, err := g.discover(ctx, opt)
+	if err != nil {
 		return err
 	}
-	if err := g.generateLambdaConfig(ctx, &config); err != nil {
-		return err
+	if opt.Analyze {
+		if d.retention, err = g.analyzeRetention(ctx, d, opt); err != nil {
+			return err
+		}
 	}
-	if err := g.generateRepositoryConfig(ctx, &config); err != nil {
-		return err
+	if opt.Merge {
+		return g.mergeConfig(ctx, configFile, d, opt)
 	}
+	config := d.config(nil, opt)
 
-	buf := bytes.NewBuffer(nil)
-	if err := yaml.NewEncoder(buf, yaml.IndentSequence(true)).Encode(config); err != nil {
+	b, err := marshalConfig(config, opt.Format)
+	if err != nil {
+		return err
+	}
+	output := opt.output(configFile)
+	if output == "-" {
+		_, err := os.Stdout.Write(b)
 		return err
 	}
 	log.Println("[notice] Generated config:")
-	os.Stderr.Write(buf.Bytes())
+	os.Stderr.Write(b)
 
-	if _, err := os.Stat(configFile); err == nil {
-		if !prompter.YN(fmt.Sprintf("%s file already exists. Overwrite?", configFile), false) {
+	if _, err := os.Stat(output); err == nil && !opt.Force {
+		if !prompter.YN(fmt.Sprintf("%s file already exists. Overwrite?", output), false) {
 			return errors.New("aborted")
 		}
 	}
-	if err := os.WriteFile(configFile, buf.Bytes(), 0644); err != nil {
+	if err := os.WriteFile(output, b, 0644); err != nil {
 		return err
 	}
-	log.Println("[notice] Saved", configFile)
+	log.Println("[notice] Saved", output)
 	return nil
 }
 
-func (g *Generator) generateClusterConfig(ctx context.Context, config *Config) error {
-	clusters, err := clusterArns(ctx, ecs.NewFromConfig(g.awsCfg))
+func marshalConfig(config *Config, format string) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	enc := yaml.NewEncoder(buf, yaml.IndentSequence(true), yaml.WithComment(repositoryComments("$.repositories", config.Repositories)))
+	if err := enc.Encode(config); err != nil {
+		return nil, err
+	}
+	if format != "json" {
+		return buf.Bytes(), nil
+	}
+	j, err := yaml.YAMLToJSON(buf.Bytes())
 	if err != nil {
-		return err
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, j, "", "  "); err != nil {
+		return nil, err
+	}
+	out.WriteString("\n")
+	return out.Bytes(), nil
+}
+
+// discovered is names of the resources found in the AWS account.
+type discovered struct {
+	clusters        []string
+	taskDefinitions []string
+	lambdaFunctions []string
+	repositories    []string
+	tags            map[string][]string           // tags of images in each repository. nil if tags are not analyzed.
+	retention       map[string]*retentionAnalysis // push history of each repository. nil if not analyzed.
+}
+
+func (g *Generator) discover(ctx context.Context, opt *GenerateOption) (*discovered, error) {
+	d := &discovered{}
+	cs := newClientsWithConfig(g.awsCfg, g.clientOptions)
+	clusters, err := clusterArns(ctx, cs.ecs)
+	if err != nil {
+		return nil, err
 	}
-	clusterNames := newSet()
 	for _, c := range clusters {
-		name := clusterArnToName(c)
-		pattern := nameToPattern(name)
-		clusterNames.add(pattern)
-		log.Printf("[debug] cluster %s -> %s", name, pattern)
+		d.clusters = append(d.clusters, clusterArnToName(c))
+	}
+
+	taskdefs, err := taskDefinitionFamilies(ctx, cs.ecs)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range taskdefs {
+		d.taskDefinitions = append(d.taskDefinitions, arnToName(n, ""))
+	}
+
+	lambdas, err := lambdaFunctions(ctx, cs.lambda)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range lambdas {
+		d.lambdaFunctions = append(d.lambdaFunctions, arnToName(*c.FunctionName, ""))
+	}
+
+	repos, err := ecrRepositories(ctx, cs.ecr)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range repos {
+		d.repositories = append(d.repositories, arnToName(*r.RepositoryName, ""))
+	}
+
+	if opt.AnalyzeTags {
+		tags := make([][]string, len(d.repositories))
+		err := parallel(ctx, DefaultParallelism, len(d.repositories), func(ctx context.Context, i int) error {
+			log.Printf("[info] analyzing tags of %s", d.repositories[i])
+			var err error
+			tags[i], err = repositoryTags(ctx, cs.ecr, d.repositories[i])
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		d.tags = make(map[string][]string, len(d.repositories))
+		for i, name := range d.repositories {
+			d.tags[name] = tags[i]
+		}
+	}
+	return d, nil
+}
+
+// config generates a config for the discovered resources that the current config doesn't match.
+// If current is nil, all the discovered resources are included.
+func (d *discovered) config(current *Config, opt *GenerateOption) *Config {
+	config := &Config{}
+	now := time.Now()
+
+	var covered []string
+	names := d.clusters
+	if current != nil {
+		names, covered = lo.FilterReject(d.clusters, func(name string, _ int) bool {
+			return !lo.ContainsBy(current.Clusters, func(cc *ClusterConfig) bool { return cc.Match(name) })
+		})
 	}
-	for _, name := range clusterNames.members() {
+	for _, name := range inferPatterns("cluster", names, covered, opt.MinGroupSize) {
 		cfg := ClusterConfig{}
 		if strings.Contains(name, "*") {
 			cfg.NamePattern = name
@@ -94,30 +180,16 @@ func (g *Generator) generateClusterConfig(ctx context.Context, config *Config) e
 		}
 		config.Clusters = append(config.Clusters, &cfg)
 	}
-	sort.Slice(config.Clusters, func(i, j int) bool {
-		if config.Clusters[i].Name != config.Clusters[j].Name {
-			return config.Clusters[i].Name < config.Clusters[j].Name
-		}
-		return config.Clusters[i].NamePattern < config.Clusters[j].NamePattern
-	})
-	return nil
-}
 
-func (g *Generator) generateTaskdefConfig(ctx context.Context, config *Config) error {
-	taskdefs, err := taskDefinitionFamilies(ctx, ecs.NewFromConfig(g.awsCfg))
-	if err != nil {
-		return err
-	}
-	taskdefNames := newSet()
-	for _, n := range taskdefs {
-		name := arnToName(n, "")
-		pattern := nameToPattern(name)
-		taskdefNames.add(pattern)
-		log.Printf("[debug] taskdef %s -> %s", name, pattern)
+	names = d.taskDefinitions
+	if current != nil {
+		names, covered = lo.FilterReject(d.taskDefinitions, func(name string, _ int) bool {
+			return !lo.ContainsBy(current.TaskDefinitions, func(tc *TaskdefConfig) bool { return tc.Match(name) })
+		})
 	}
-	for _, name := range taskdefNames.members() {
+	for _, name := range inferPatterns("taskdef", names, covered, opt.MinGroupSize) {
 		cfg := TaskdefConfig{
-			KeepCount: int64(DefaultKeepCount),
+			KeepCount: opt.KeepCount,
 		}
 		if strings.Contains(name, "*") {
 			cfg.NamePattern = name
@@ -126,30 +198,16 @@ func (g *Generator) generateTaskdefConfig(ctx context.Context, config *Config) e
 		}
 		config.TaskDefinitions = append(config.TaskDefinitions, &cfg)
 	}
-	sort.Slice(config.TaskDefinitions, func(i, j int) bool {
-		if config.TaskDefinitions[i].Name != config.TaskDefinitions[j].Name {
-			return config.TaskDefinitions[i].Name < config.TaskDefinitions[j].Name
-		}
-		return config.TaskDefinitions[i].NamePattern < config.TaskDefinitions[j].NamePattern
-	})
-	return nil
-}
 
-func (g *Generator) generateLambdaConfig(ctx context.Context, config *Config) error {
-	lambdas, err := lambdaFunctions(ctx, lambda.NewFromConfig(g.awsCfg))
-	if err != nil {
-		return err
+	names = d.lambdaFunctions
+	if current != nil {
+		names, covered = lo.FilterReject(d.lambdaFunctions, func(name string, _ int) bool {
+			return !lo.ContainsBy(current.LambdaFunctions, func(lc *LambdaConfig) bool { return lc.Match(name) })
+		})
 	}
-	lambdaNames := newSet()
-	for _, c := range lambdas {
-		name := arnToName(*c.FunctionName, "")
-		pattern := nameToPattern(name)
-		lambdaNames.add(pattern)
-		log.Printf("[debug] lambda %s -> %s", name, pattern)
-	}
-	for _, name := range lambdaNames.members() {
+	for _, name := range inferPatterns("lambda", names, covered, opt.MinGroupSize) {
 		cfg := LambdaConfig{
-			KeepCount:   int64(DefaultKeepCount),
+			KeepCount: opt.KeepCount,
 		}
 		if strings.Contains(name, "*") {
 			cfg.NamePattern = name
@@ -158,45 +216,43 @@ func (g *Generator) generateLambdaConfig(ctx context.Context, config *Config) er
 		}
 		config.LambdaFunctions = append(config.LambdaFunctions, &cfg)
 	}
-	sort.Slice(config.LambdaFunctions, func(i, j int) bool {
-		if config.LambdaFunctions[i].Name != config.LambdaFunctions[j].Name {
-			return config.LambdaFunctions[i].Name < config.LambdaFunctions[j].Name
-		}
-		return config.LambdaFunctions[i].NamePattern < config.LambdaFunctions[j].NamePattern
-	})
-	return nil
-}
 
-func (g *Generator) generateRepositoryConfig(ctx context.Context, config *Config) error {
-	repos, err := ecrRepositories(ctx, ecr.NewFromConfig(g.awsCfg))
-	if err != nil {
-		return err
-	}
-	repoNames := newSet()
-	for _, r := range repos {
-		name := arnToName(*r.RepositoryName, "")
-		pattern := nameToPattern(name)
-		repoNames.add(pattern)
-		log.Printf("[debug] ECR %s -> %s", name, pattern)
+	names = d.repositories
+	if current != nil {
+		names, covered = lo.FilterReject(d.repositories, func(name string, _ int) bool {
+			return current.RepositoryConfig(RepositoryName(name)) == nil
+		})
 	}
-	for _, name := range repoNames.members() {
+	for _, name := range inferPatterns("ECR", names, covered, opt.MinGroupSize) {
 		cfg := RepositoryConfig{
-			KeepCount:       int64(DefaultKeepCount),
-			Expires:         DefaultExpiresStr,
-			KeepTagPatterns: DefaultKeepTagPatterns,
+			KeepCount:       opt.KeepCount,
+			Expires:         opt.Expires,
+			KeepTagPatterns: opt.KeepTagPatterns,
 		}
 		if strings.Contains(name, "*") {
 			cfg.NamePattern = name
 		} else {
 			cfg.Name = RepositoryName(name)
 		}
+		if d.tags != nil {
+			var tags []string
+			for _, n := range names {
+				if wildcard.Match(name, n) {
+					tags = append(tags, d.tags[n]...)
+				}
+			}
+			cfg.KeepTagPatterns, cfg.Semver = suggestTagRetention(tags, opt.KeepTagPatterns)
+		}
+		if d.retention != nil {
+			a := &retentionAnalysis{}
+			for _, n := range names {
+				if r, ok := d.retention[n]; ok && wildcard.Match(name, n) {
+					a.merge(r)
+				}
+			}
+			a.suggest(&cfg, now)
+		}
 		config.Repositories = append(config.Repositories, &cfg)
 	}
-	sort.Slice(config.Repositories, func(i, j int) bool {
-		if config.Repositories[i].Name != config.Repositories[j].Name {
-			return config.Repositories[i].Name < config.Repositories[j].Name
-		}
-		return config.Repositories[i].NamePattern < config.Repositories[j].NamePattern
-	})
-	return nil
+	return config
 }
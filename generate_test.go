@@ -0,0 +1,120 @@
+package ecrm_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+)
+
+var generateOption = &ecrm.GenerateOption{
+	KeepCount:       5,
+	Expires:         "30d",
+	KeepTagPatterns: []string{"latest"},
+	MinGroupSize:    2,
+}
+
+func TestMergeConfig(t *testing.T) {
+	src, err := os.ReadFile("testdata/merge.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := ecrm.LoadConfig(context.Background(), "testdata/merge.yaml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := ecrm.DiscoveredConfig(current, generateOption,
+		[]string{"prod-web", "stg-web", "stg-batch"},
+		[]string{"prod-app", "stg-app"},
+		[]string{"batch-job"},
+		[]string{"prod/app", "dev/app", "dev/worker", "tools"},
+	)
+	merged, err := ecrm.AppendConfigEntries(src, added)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect, err := os.ReadFile("testdata/merge.expected.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(expect), string(merged)); diff != "" {
+		t.Errorf("unexpected merged config (-want +got):\n%s", diff)
+	}
+
+	// merging again adds nothing
+	path := t.TempDir() + "/merged.yaml"
+	if err := os.WriteFile(path, merged, 0644); err != nil {
+		t.Fatal(err)
+	}
+	current, err = ecrm.LoadConfig(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added = ecrm.DiscoveredConfig(current, generateOption,
+		[]string{"prod-web", "stg-web", "stg-batch"},
+		[]string{"prod-app", "stg-app"},
+		[]string{"batch-job"},
+		[]string{"prod/app", "dev/app", "dev/worker", "tools"},
+	)
+	again, err := ecrm.AppendConfigEntries(merged, added)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(merged), string(again)); diff != "" {
+		t.Errorf("merging again must not change the config (-want +got):\n%s", diff)
+	}
+}
+
+func TestGenerateConfigJSON(t *testing.T) {
+	opt := &ecrm.GenerateOption{
+		Format:          "json",
+		KeepCount:       3,
+		Expires:         "90d",
+		KeepTagPatterns: []string{"latest", "release-*"},
+		MinGroupSize:    2,
+	}
+	if err := opt.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	config := ecrm.DiscoveredConfig(nil, opt,
+		[]string{"prod-web"}, []string{"prod-app"}, []string{"batch-job"}, []string{"prod/app", "prod/worker", "tools"},
+	)
+	b, err := ecrm.MarshalConfig(config, opt.Format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := t.TempDir() + "/ecrm.json"
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ecrm.LoadConfig(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := loaded.Repositories[0]
+	if rc.NamePattern != "prod/*" || rc.Expires != "90d" || rc.KeepCount != 3 {
+		t.Errorf("unexpected repository config: %#v", rc)
+	}
+	if diff := cmp.Diff([]string{"latest", "release-*"}, rc.KeepTagPatterns); diff != "" {
+		t.Errorf("unexpected keep_tag_patterns: %s", diff)
+	}
+	if loaded.Repositories[1].Name != "tools" {
+		t.Errorf("unexpected repository config: %#v", loaded.Repositories[1])
+	}
+}
+
+func TestGenerateOptionValidate(t *testing.T) {
+	for _, opt := range []*ecrm.GenerateOption{
+		{Merge: true, Format: "json", Expires: "30d"},
+		{KeepCount: -1, Expires: "30d"},
+		{MinGroupSize: -1, Expires: "30d"},
+		{Expires: "forever"},
+		{Expires: "30d", KeepTagPatterns: []string{"!"}},
+	} {
+		if err := opt.Validate(); err == nil {
+			t.Errorf("expected error for %#v", opt)
+		}
+	}
+}
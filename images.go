@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"os"
 	"sort"
 	"strings"
 )
@@ -51,8 +50,34 @@ func (u ImageURI) Short() string {
 	return strings.SplitN(string(u), "/", 2)[1]
 }
 
-type Images map[ImageURI]set
+// UsedBy is a resource using an image.
+type UsedBy struct {
+	ARN       string `json:"arn"`
+	Kind      string `json:"kind,omitempty"`
+	Container string `json:"container,omitempty"`
+}
+
+// Kinds of the resources using images.
+const (
+	UsedByECSTask           = "ecs_task"
+	UsedByECSTaskDefinition = "ecs_task_definition"
+	UsedByLambdaFunction    = "lambda_function"
+	UsedByScannedFile       = "scanned_file"
+)
 
+func (u UsedBy) String() string {
+	if u.Container != "" {
+		return fmt.Sprintf("%s (container %s)", u.ARN, u.Container)
+	}
+	return u.ARN
+}
+
+type usages map[UsedBy]struct{}
+
+// Images is a set of images in use and the resources using them.
+type Images map[ImageURI]usages
+
+// Print writes the image URIs as a JSON array (the scanned file format v1).
 func (i Images) Print(w io.Writer) error {
 	m := make([]string, 0, len(i))
 	for k := range i {
@@ -67,35 +92,55 @@ func (i Images) Print(w io.Writer) error {
 	return nil
 }
 
+// LoadFile loads the scanned file. Both the format v1 (a JSON array of image URIs) and v2 are supported.
 func (i Images) LoadFile(filename string) error {
-	f, err := os.Open(filename)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
-	}
-	defer f.Close()
-	in := []string{}
-	if err := json.NewDecoder(f).Decode(&in); err != nil {
-		return fmt.Errorf("failed to decode images: %w", err)
-	}
-	for _, u := range in {
-		i[ImageURI(u)] = newSet(filename)
-	}
-	return nil
+	_, err := i.loadScanResult(filename)
+	return err
 }
 
+// Add adds the image used by the resource. It returns false if the image is already used by the resource.
 func (i Images) Add(u ImageURI, usedBy string) bool {
+	return i.AddUsage(u, UsedBy{ARN: usedBy})
+}
+
+// AddUsage adds the image used by the resource. It returns false if the image is already used by the resource.
+func (i Images) AddUsage(u ImageURI, by UsedBy) bool {
 	if _, ok := i[u]; !ok {
-		i[u] = newSet()
+		i[u] = make(usages)
 	}
-	return i[u].add(usedBy)
+	if _, ok := i[u][by]; ok {
+		return false // already exists
+	}
+	i[u][by] = struct{}{}
+	return true
 }
 
 func (i Images) Contains(u ImageURI) bool {
-	return !i[u].isEmpty()
+	return len(i[u]) > 0
+}
+
+// UsedBy returns the resources using the image, sorted by ARN, kind and container name.
+func (i Images) UsedBy(u ImageURI) []UsedBy {
+	by := make([]UsedBy, 0, len(i[u]))
+	for b := range i[u] {
+		by = append(by, b)
+	}
+	sort.Slice(by, func(a, b int) bool {
+		if by[a].ARN != by[b].ARN {
+			return by[a].ARN < by[b].ARN
+		}
+		if by[a].Kind != by[b].Kind {
+			return by[a].Kind < by[b].Kind
+		}
+		return by[a].Container < by[b].Container
+	})
+	return by
 }
 
 func (i Images) Merge(j Images) {
 	for k, v := range j {
-		i[k] = i[k].union(v)
+		for by := range v {
+			i.AddUsage(k, by)
+		}
 	}
 }
@@ -3,7 +3,9 @@ package ecrm_test
 import (
 	"bytes"
 	"encoding/json"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/fujiwara/ecrm"
 	"github.com/google/go-cmp/cmp"
@@ -116,3 +118,107 @@ func TestPrintImages(t *testing.T) {
 		t.Errorf("unexpected images: %s", diff)
 	}
 }
+
+func TestLoadImagesV2(t *testing.T) {
+	images := make(ecrm.Images)
+	if err := images.LoadFile("testdata/images_v2.json"); err != nil {
+		t.Fatal(err)
+	}
+	if err := images.LoadFile("testdata/images.json"); err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 4 {
+		t.Errorf("unexpected images: %d", len(images))
+	}
+	if diff := cmp.Diff([]ecrm.UsedBy{
+		{ARN: "arn:aws:ecs:ap-northeast-1:0123456789012:task-definition/foo:3", Kind: ecrm.UsedByECSTaskDefinition, Container: "app"},
+		{ARN: "arn:aws:ecs:ap-northeast-1:0123456789012:task/default/0123456789abcdef0123456789abcdef", Kind: ecrm.UsedByECSTask, Container: "app"},
+		{ARN: "testdata/images.json", Kind: ecrm.UsedByScannedFile},
+	}, images.UsedBy("0123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/foo/bar:fe668fb9")); diff != "" {
+		t.Errorf("unexpected used_by (-want +got):\n%s", diff)
+	}
+}
+
+func TestLoadImagesUnsupportedVersion(t *testing.T) {
+	path := t.TempDir() + "/images.json"
+	if err := os.WriteFile(path, []byte(`{"version":3,"images":[]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := make(ecrm.Images).LoadFile(path); err == nil {
+		t.Error("expected error for unsupported version")
+	}
+}
+
+func TestPrintScanResult(t *testing.T) {
+	images := make(ecrm.Images)
+	images.AddUsage("0123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/foo/bar:fe668fb9", ecrm.UsedBy{
+		ARN:       "arn:aws:ecs:ap-northeast-1:0123456789012:task-definition/foo:3",
+		Kind:      ecrm.UsedByECSTaskDefinition,
+		Container: "app",
+	})
+	meta := ecrm.ScanMetadata{
+		AccountID:   "0123456789012",
+		Region:      "ap-northeast-1",
+		ECRMVersion: "v0.0.0",
+		ScannedAt:   time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC),
+		ConfigHash:  "sha256:abcd",
+	}
+	path := t.TempDir() + "/images.json"
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := images.PrintScanResult(f, meta); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	restored := make(ecrm.Images)
+	if err := restored.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(images, restored); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+
+	var r ecrm.ScanResult
+	b, _ := os.ReadFile(path)
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Version != ecrm.ScanResultVersion {
+		t.Errorf("unexpected version: %d", r.Version)
+	}
+	if diff := cmp.Diff(meta, r.Metadata); diff != "" {
+		t.Errorf("unexpected metadata (-want +got):\n%s", diff)
+	}
+}
+
+func TestCheckScanAge(t *testing.T) {
+	now := time.Date(2024, 10, 5, 0, 0, 0, 0, time.UTC) // 4 days after the scan of images_v2.json
+	if err := ecrm.CheckScanAge([]string{"testdata/images_v2.json"}, 7*24*time.Hour, now); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := ecrm.CheckScanAge([]string{"testdata/images_v2.json"}, 3*24*time.Hour, now); err == nil {
+		t.Error("expected error for the stale scanned file")
+	}
+	// format v1 has no timestamp
+	if err := ecrm.CheckScanAge([]string{"testdata/images_v2.json", "testdata/images.json"}, 7*24*time.Hour, now); err == nil {
+		t.Error("expected error for the scanned file without timestamp")
+	}
+}
+
+func TestOptionMaxScanAge(t *testing.T) {
+	for _, s := range []string{"7d", "12h", ""} {
+		opt := &ecrm.Option{Scan: true, MaxScanAge: s}
+		if err := opt.Validate(); err != nil {
+			t.Errorf("unexpected error for %q: %s", s, err)
+		}
+	}
+	for _, s := range []string{"forever", "0d"} {
+		opt := &ecrm.Option{Scan: true, MaxScanAge: s}
+		if err := opt.Validate(); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package ecrm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// DefaultsConfig is default values for the entries that don't set them.
+type DefaultsConfig struct {
+	TaskDefinitions *TaskdefDefaults    `yaml:"task_definitions,omitempty"`
+	LambdaFunctions *LambdaDefaults     `yaml:"lambda_functions,omitempty"`
+	Repositories    *RepositoryDefaults `yaml:"repositories,omitempty"`
+}
+
+type TaskdefDefaults struct {
+	KeepCount int64 `yaml:"keep_count,omitempty"`
+}
+
+type LambdaDefaults struct {
+	KeepCount int64 `yaml:"keep_count,omitempty"`
+}
+
+type RepositoryDefaults struct {
+	Expires         string        `yaml:"expires,omitempty"`
+	KeepCount       int64         `yaml:"keep_count,omitempty"`
+	KeepTagPatterns []string      `yaml:"keep_tag_patterns,omitempty"`
+	TagRegexp       string        `yaml:"tag_regexp,omitempty"`
+	Semver          *SemverConfig `yaml:"semver,omitempty"`
+}
+
+// apply sets the default values to the entries that don't set them.
+func (d *DefaultsConfig) apply(c *Config) {
+	if d == nil {
+		return
+	}
+	if td := d.TaskDefinitions; td != nil {
+		for _, tc := range c.TaskDefinitions {
+			if tc.KeepCount == 0 {
+				tc.KeepCount = td.KeepCount
+			}
+		}
+	}
+	if ld := d.LambdaFunctions; ld != nil {
+		for _, lc := range c.LambdaFunctions {
+			if lc.KeepCount == 0 {
+				lc.KeepCount = ld.KeepCount
+			}
+		}
+	}
+	if rd := d.Repositories; rd != nil {
+		for _, rc := range c.Repositories {
+			if rc.Expires == "" {
+				rc.Expires = rd.Expires
+			}
+			if rc.KeepCount == 0 {
+				rc.KeepCount = rd.KeepCount
+			}
+			if rc.KeepTagPatterns == nil {
+				rc.KeepTagPatterns = rd.KeepTagPatterns
+			}
+			if rc.TagRegexp == "" {
+				rc.TagRegexp = rd.TagRegexp
+			}
+			if rc.Semver == nil {
+				rc.Semver = rd.Semver
+			}
+		}
+	}
+}
+
+// includeError is an error in the included config file.
+type includeError struct {
+	path string
+	err  error
+}
+
+func (e *includeError) Error() string {
+	return fmt.Sprintf("include %s: %s", e.path, e.err)
+}
+
+func (e *includeError) Unwrap() error {
+	return e.err
+}
+
+// includedPath returns the path of the innermost included file that causes the error.
+func includedPath(err error) string {
+	var path string
+	var ie *includeError
+	for errors.As(err, &ie) {
+		path = ie.path
+		err = ie.err
+	}
+	return path
+}
+
+// includeFiles returns the files to be included by the pattern relative to the including path.
+// Glob patterns are expanded only for local files.
+func includeFiles(path, pattern string) ([]string, error) {
+	if isConfigURL(pattern) {
+		return []string{pattern}, nil
+	}
+	if u, ok := parseConfigURL(path); ok {
+		return []string{resolveConfigURL(u, pattern)}, nil
+	}
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(path), pattern)
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid include pattern %s: %w", pattern, err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("include %s: no such files", pattern)
+	}
+	return files, nil
+}
+
+// loadConfigFile loads the config file, merges the included files and applies the defaults.
+// parents are the absolute paths of the including files to detect include cycles.
+func loadConfigFile(ctx context.Context, path string, opt *ConfigOption, parents []string, decodeOpts ...yaml.DecodeOption) (*Config, error) {
+	abs := path
+	if !isConfigURL(path) {
+		var err error
+		if abs, err = filepath.Abs(path); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range parents {
+		if p == abs {
+			return nil, fmt.Errorf("include cycle detected: %s", strings.Join(append(parents, abs), " -> "))
+		}
+	}
+	b, err := readConfigFile(ctx, path, opt)
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseConfig(b, decodeOpts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(parents) > 0 && (c.MatchStrategy != "" || c.RepositoryTags || c.RepositoryTagsOptIn) {
+		return nil, errors.New("match_strategy, repository_tags and repository_tags_opt_in are allowed only in the main config")
+	}
+
+	stack := append(parents[:len(parents):len(parents)], abs)
+	for _, pattern := range c.Include {
+		files, err := includeFiles(path, pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			log.Println("[debug] including config file:", f)
+			ic, err := loadConfigFile(ctx, f, opt, stack, decodeOpts...)
+			if err != nil {
+				return nil, &includeError{path: f, err: err}
+			}
+			c.Clusters = append(c.Clusters, ic.Clusters...)
+			c.TaskDefinitions = append(c.TaskDefinitions, ic.TaskDefinitions...)
+			c.LambdaFunctions = append(c.LambdaFunctions, ic.LambdaFunctions...)
+			c.Repositories = append(c.Repositories, ic.Repositories...)
+		}
+	}
+	// the defaults of the included files are already applied to their entries.
+	c.Defaults.apply(c)
+	return c, nil
+}
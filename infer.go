@@ -0,0 +1,178 @@
+package ecrm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/fujiwara/ecrm/wildcard"
+)
+
+// DefaultMinGroupSize is the minimum number of names to be grouped into a pattern.
+var DefaultMinGroupSize = 2
+
+// environmentNames are well-known environment names used as suffixes of resource names.
+var environmentNames = []string{
+	"prod", "production", "prd",
+	"stg", "stage", "staging",
+	"dev", "develop", "development",
+	"test", "qa", "sandbox",
+}
+
+const (
+	nameSeparators        = "/_-."
+	environmentSeparators = "/_-"
+)
+
+// inferPatterns infers wildcard patterns from the names.
+//
+// Names sharing an environment suffix (e.g. "api-prod" and "web-prod") are grouped into "*-prod" first.
+// Then names sharing the longest common prefix that ends with a separator (e.g. "my-app-web" and "my-app-worker")
+// are grouped into "my-app-*".
+// A group must have at least minGroupSize names (and two names at least), and its pattern must not match covered names
+// or names in other groups. Names that don't belong to any group are kept as is, so a single name is never widened
+// to a pattern that matches unrelated resources in the future.
+//
+// The result is sorted; patterns first, then names.
+func inferPatterns(kind string, names, covered []string, minGroupSize int) []string {
+	minGroupSize = max(minGroupSize, 2)
+	coveredNames := newSet(covered...)
+	assigned := make(map[string]string, len(names))
+	patterns := newSet()
+
+	group := func(pattern string) bool {
+		var members []string
+		for _, name := range covered {
+			if wildcard.Match(pattern, name) {
+				return false
+			}
+		}
+		for _, name := range names {
+			if !wildcard.Match(pattern, name) {
+				continue
+			}
+			if _, ok := assigned[name]; ok || coveredNames.contains(name) {
+				return false
+			}
+			members = append(members, name)
+		}
+		if len(members) < minGroupSize {
+			return false
+		}
+		for _, name := range members {
+			assigned[name] = pattern
+		}
+		patterns.add(pattern)
+		return true
+	}
+
+	for _, env := range environmentNames {
+		for _, sep := range environmentSeparators {
+			group("*" + string(sep) + env)
+		}
+	}
+
+	prefixes := newSet()
+	for _, name := range names {
+		for i, r := range name {
+			if strings.ContainsRune(nameSeparators, r) && i+1 < len(name) {
+				prefixes.add(name[:i+1])
+			}
+		}
+	}
+	ps := prefixes.members()
+	sort.Slice(ps, func(i, j int) bool {
+		if len(ps[i]) != len(ps[j]) {
+			return len(ps[i]) > len(ps[j])
+		}
+		return ps[i] < ps[j]
+	})
+	for _, p := range ps {
+		group(p + "*")
+	}
+
+	for _, name := range names {
+		pattern, ok := assigned[name]
+		if !ok {
+			pattern = name
+			patterns.add(name)
+		}
+		log.Printf("[debug] %s %s -> %s", kind, name, pattern)
+	}
+
+	result := patterns.members()
+	sort.Slice(result, func(i, j int) bool {
+		pi, pj := strings.Contains(result[i], "*"), strings.Contains(result[j], "*")
+		if pi != pj {
+			return pi
+		}
+		return result[i] < result[j]
+	})
+	return result
+}
+
+// pointerTags are well-known mutable tags pointing to the current images. They are worth keeping.
+var pointerTags = []string{"latest", "stable", "main", "master", "prod", "production", "staging"}
+
+var gitSHATagRe = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// semverSuggestion is a suggested semver retention for repositories tagged by semantic versions.
+var semverSuggestion = SemverConfig{KeepMajor: 2, KeepMinor: 3, KeepPatch: 1}
+
+// minSemverTags is the minimum number of semver tags to suggest the semver retention.
+const minSemverTags = 3
+
+// suggestTagRetention suggests keep_tag_patterns and semver by the shapes of the existing tags.
+//
+//   - pointer tags (latest, stable, main, ...) are added to keep_tag_patterns.
+//   - semver tags (most of the tags) suggest the semver retention.
+//   - git SHA tags are not kept. They are expired by expires and keep_count.
+func suggestTagRetention(tags []string, keepTagPatterns []string) ([]string, *SemverConfig) {
+	existing := newSet(tags...)
+	patterns := append([]string{}, keepTagPatterns...)
+	for _, tag := range pointerTags {
+		if existing.contains(tag) && !matchPatterns(patterns, tag) {
+			patterns = append(patterns, tag)
+		}
+	}
+
+	var semvers, shas int
+	for _, tag := range tags {
+		if _, ok := parseSemverTag(tag); ok {
+			semvers++
+		} else if gitSHATagRe.MatchString(tag) {
+			shas++
+		}
+	}
+	log.Printf("[debug] tags: %d total, %d semver, %d git SHA", len(tags), semvers, shas)
+	if semvers >= minSemverTags && semvers*2 >= len(tags) {
+		s := semverSuggestion
+		return patterns, &s
+	}
+	return patterns, nil
+}
+
+// repositoryTags returns the tags of the images in the repository.
+func repositoryTags(ctx context.Context, client ECRClient, repo string) ([]string, error) {
+	var tags []string
+	pager := ecr.NewDescribeImagesPaginator(client, &ecr.DescribeImagesInput{
+		RepositoryName: aws.String(repo),
+		Filter:         &ecrTypes.DescribeImagesFilter{TagStatus: ecrTypes.TagStatusTagged},
+	})
+	for pager.HasMorePages() {
+		out, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe images in %s: %w", repo, err)
+		}
+		for _, d := range out.ImageDetails {
+			tags = append(tags, d.ImageTags...)
+		}
+	}
+	return tags, nil
+}
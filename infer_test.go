@@ -0,0 +1,134 @@
+package ecrm_test
+
+import (
+	"testing"
+
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+)
+
+var inferPatternsTests = []struct {
+	name         string
+	names        []string
+	covered      []string
+	minGroupSize int
+	expect       []string
+}{
+	{
+		name:         "longest common prefix",
+		names:        []string{"my-app-web", "my-app-worker", "my-tool", "other"},
+		minGroupSize: 2,
+		expect:       []string{"my-app-*", "my-tool", "other"},
+	},
+	{
+		name:         "environment suffixes",
+		names:        []string{"api-prod", "web-prod", "api-stg", "web_stg", "batch"},
+		minGroupSize: 2,
+		expect:       []string{"*-prod", "api-stg", "batch", "web_stg"},
+	},
+	{
+		name:         "minimum group size",
+		names:        []string{"team-a/app", "team-a/worker", "team-b/app", "team-b/worker", "team-b/batch"},
+		minGroupSize: 3,
+		expect:       []string{"team-b/*", "team-a/app", "team-a/worker"},
+	},
+	{
+		name:         "nested prefixes",
+		names:        []string{"team-a/app", "team-a/worker", "team-b/app", "team-b/worker"},
+		minGroupSize: 2,
+		expect:       []string{"team-a/*", "team-b/*"},
+	},
+	{
+		name:         "patterns must not match covered names",
+		names:        []string{"stg-web", "stg-batch"},
+		covered:      []string{"stg-app"},
+		minGroupSize: 2,
+		expect:       []string{"stg-batch", "stg-web"},
+	},
+	{
+		name:         "single names",
+		names:        []string{"prod-web", "stg-web"},
+		minGroupSize: 1,
+		expect:       []string{"prod-web", "stg-web"},
+	},
+}
+
+func TestInferPatterns(t *testing.T) {
+	for _, tt := range inferPatternsTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ecrm.InferPatterns("test", tt.names, tt.covered, tt.minGroupSize)
+			if diff := cmp.Diff(tt.expect, got); diff != "" {
+				t.Errorf("unexpected patterns (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+var suggestTagRetentionTests = []struct {
+	name           string
+	tags           []string
+	expectPatterns []string
+	expectSemver   *ecrm.SemverConfig
+}{
+	{
+		name:           "semver",
+		tags:           []string{"latest", "v1.0.0", "v1.1.0", "v2.0.0"},
+		expectPatterns: []string{"latest"},
+		expectSemver:   &ecrm.SemverConfig{KeepMajor: 2, KeepMinor: 3, KeepPatch: 1},
+	},
+	{
+		name:           "git SHA",
+		tags:           []string{"fe668fb9", "0a1b2c3", "latest", "main", "v1.0.0"},
+		expectPatterns: []string{"latest", "main"},
+	},
+	{
+		name:           "few semver tags",
+		tags:           []string{"v1.0.0", "v1.1.0", "stable"},
+		expectPatterns: []string{"latest", "stable"},
+	},
+}
+
+func TestSuggestTagRetention(t *testing.T) {
+	for _, tt := range suggestTagRetentionTests {
+		t.Run(tt.name, func(t *testing.T) {
+			patterns, semver := ecrm.SuggestTagRetention(tt.tags, []string{"latest"})
+			if diff := cmp.Diff(tt.expectPatterns, patterns); diff != "" {
+				t.Errorf("unexpected keep_tag_patterns (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.expectSemver, semver); diff != "" {
+				t.Errorf("unexpected semver (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGenerateConfigAnalyzeTags(t *testing.T) {
+	opt := &ecrm.GenerateOption{
+		KeepCount:       5,
+		Expires:         "30d",
+		KeepTagPatterns: []string{"latest"},
+		MinGroupSize:    2,
+		AnalyzeTags:     true,
+	}
+	config := ecrm.DiscoveredRepositoriesConfig(opt, map[string][]string{
+		"lib/core":   {"v1.0.0", "v1.1.0"},
+		"lib/client": {"v1.0.0", "stable"},
+		"app":        {"fe668fb9", "0a1b2c3", "main"},
+	})
+	if len(config.Repositories) != 2 {
+		t.Fatalf("unexpected repositories: %v", config.Repositories)
+	}
+	lib, app := config.Repositories[0], config.Repositories[1]
+	if lib.NamePattern != "lib/*" || lib.Semver == nil {
+		t.Errorf("unexpected repository config: %#v", lib)
+	}
+	if diff := cmp.Diff([]string{"latest", "stable"}, lib.KeepTagPatterns); diff != "" {
+		t.Errorf("unexpected keep_tag_patterns of lib/* (-want +got):\n%s", diff)
+	}
+	if app.Name != "app" || app.Semver != nil {
+		t.Errorf("unexpected repository config: %#v", app)
+	}
+	if diff := cmp.Diff([]string{"latest", "main"}, app.KeepTagPatterns); diff != "" {
+		t.Errorf("unexpected keep_tag_patterns of app (-want +got):\n%s", diff)
+	}
+}
@@ -20,57 +20,62 @@ func (s *Scanner) scanLambdaFunctions(ctx context.Context, lcs []*LambdaConfig)
 		return err
 	}
 
+	type functionConfig struct {
+		name      string
+		keepCount int64
+	}
+	var targets []functionConfig
 	for _, fn := range funcs {
-		var name string
-		var keepCount int64
 		for _, tc := range lcs {
-			fn := *fn.FunctionName
-			if tc.Match(fn) {
-				name = fn
-				keepCount = tc.KeepCount
+			if name := aws.ToString(fn.FunctionName); tc.Match(name) {
+				targets = append(targets, functionConfig{name: name, keepCount: tc.KeepCount})
 				break
 			}
 		}
-		if name == "" {
-			continue
-		}
-		log.Printf("[debug] Checking Lambda function %s latest %d versions", name, keepCount)
-		aliases, err := s.getLambdaAliases(ctx, name)
+	}
+	return parallel(ctx, s.parallelism, len(targets), func(ctx context.Context, i int) error {
+		return s.scanLambdaFunction(ctx, targets[i].name, targets[i].keepCount)
+	})
+}
+
+// scanLambdaFunction scans the aliased versions and the latest keepCount versions of the function.
+func (s *Scanner) scanLambdaFunction(ctx context.Context, name string, keepCount int64) error {
+	log.Printf("[debug] Checking Lambda function %s latest %d versions", name, keepCount)
+	aliases, err := s.getLambdaAliases(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to get lambda aliases: %w", err)
+	}
+	p := lambda.NewListVersionsByFunctionPaginator(
+		s.lambda,
+		&lambda.ListVersionsByFunctionInput{
+			FunctionName: &name,
+		},
+	)
+	var versions []lambdaTypes.FunctionConfiguration
+	for p.HasMorePages() {
+		r, err := p.NextPage(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to get lambda aliases: %w", err)
+			return err
 		}
-		p := lambda.NewListVersionsByFunctionPaginator(
-			s.lambda,
-			&lambda.ListVersionsByFunctionInput{
-				FunctionName: fn.FunctionName,
-			},
-		)
-		var versions []lambdaTypes.FunctionConfiguration
-		for p.HasMorePages() {
-			r, err := p.NextPage(ctx)
-			if err != nil {
-				return err
-			}
-			versions = append(versions, r.Versions...)
+		versions = append(versions, r.Versions...)
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return lambdaVersionInt64(*versions[j].Version) < lambdaVersionInt64(*versions[i].Version)
+	})
+	kept := int64(0)
+	// scan the versions
+	// 1. aliased versions
+	// 2. latest keepCount versions
+	scanVersions := lo.Filter(versions, func(v lambdaTypes.FunctionConfiguration, _ int) bool {
+		if _, ok := aliases[*v.Version]; ok {
+			return ok
 		}
-		sort.SliceStable(versions, func(i, j int) bool {
-			return lambdaVersionInt64(*versions[j].Version) < lambdaVersionInt64(*versions[i].Version)
-		})
-		kept := int64(0)
-		// scan the versions
-		// 1. aliased versions
-		// 2. latest keepCount versions
-		scanVersions := lo.Filter(versions, func(v lambdaTypes.FunctionConfiguration, _ int) bool {
-			if _, ok := aliases[*v.Version]; ok {
-				return ok
-			}
-			kept++
-			return kept <= keepCount
-		})
-		for _, v := range scanVersions {
-			if err := s.scanLambdaFunctionArn(ctx, *v.FunctionArn, aliases[*v.Version]...); err != nil {
-				return err
-			}
+		kept++
+		return kept <= keepCount
+	})
+	for _, v := range scanVersions {
+		if err := s.scanLambdaFunctionArn(ctx, *v.FunctionArn, aliases[*v.Version]...); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -90,7 +95,7 @@ func (s *Scanner) scanLambdaFunctionArn(ctx context.Context, functionArn string,
 		return nil
 	}
 	log.Println("[debug] ImageUri", u)
-	if s.Images.Add(u, functionArn) {
+	if s.AddUsage(u, UsedBy{ARN: functionArn, Kind: UsedByLambdaFunction}) {
 		if len(aliasNames) == 0 {
 			log.Printf("[info] %s is in use by Lambda function %s", u.String(), functionArn)
 		} else {
@@ -0,0 +1,441 @@
+package ecrm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/fatih/color"
+	"github.com/fujiwara/ecrm/wildcard"
+	"github.com/goccy/go-yaml"
+	"github.com/olekukonko/tablewriter"
+)
+
+const (
+	lifecycleTagStatusTagged   = "tagged"
+	lifecycleTagStatusUntagged = "untagged"
+	lifecycleTagStatusAny      = "any"
+
+	lifecycleCountTypeImageCountMoreThan = "imageCountMoreThan"
+	lifecycleCountTypeSinceImagePushed   = "sinceImagePushed"
+
+	lifecycleActionExpire = "expire"
+)
+
+// LifecyclePolicy represents an ECR lifecycle policy document.
+type LifecyclePolicy struct {
+	Rules []*LifecyclePolicyRule `json:"rules"`
+}
+
+type LifecyclePolicyRule struct {
+	RulePriority int                      `json:"rulePriority"`
+	Description  string                   `json:"description,omitempty"`
+	Selection    LifecyclePolicySelection `json:"selection"`
+	Action       LifecyclePolicyAction    `json:"action"`
+}
+
+type LifecyclePolicySelection struct {
+	TagStatus      string   `json:"tagStatus"`
+	TagPatternList []string `json:"tagPatternList,omitempty"`
+	TagPrefixList  []string `json:"tagPrefixList,omitempty"`
+	CountType      string   `json:"countType"`
+	CountUnit      string   `json:"countUnit,omitempty"`
+	CountNumber    int64    `json:"countNumber"`
+}
+
+type LifecyclePolicyAction struct {
+	Type string `json:"type"`
+}
+
+// ParseLifecyclePolicy parses an ECR lifecycle policy document.
+func ParseLifecyclePolicy(b []byte) (*LifecyclePolicy, error) {
+	var p LifecyclePolicy
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse lifecycle policy: %w", err)
+	}
+	for _, r := range p.Rules {
+		switch r.Selection.TagStatus {
+		case lifecycleTagStatusTagged:
+			if len(r.Selection.TagPatternList) == 0 && len(r.Selection.TagPrefixList) == 0 {
+				return nil, fmt.Errorf("rule %d: tagPatternList or tagPrefixList is required for tagged images", r.RulePriority)
+			}
+		case lifecycleTagStatusUntagged, lifecycleTagStatusAny:
+		default:
+			return nil, fmt.Errorf("rule %d: unknown tagStatus %s", r.RulePriority, r.Selection.TagStatus)
+		}
+		switch r.Selection.CountType {
+		case lifecycleCountTypeImageCountMoreThan:
+		case lifecycleCountTypeSinceImagePushed:
+			if r.Selection.CountUnit != "days" {
+				return nil, fmt.Errorf("rule %d: unknown countUnit %s", r.RulePriority, r.Selection.CountUnit)
+			}
+		default:
+			return nil, fmt.Errorf("rule %d: unknown countType %s", r.RulePriority, r.Selection.CountType)
+		}
+	}
+	sort.SliceStable(p.Rules, func(i, j int) bool {
+		return p.Rules[i].RulePriority < p.Rules[j].RulePriority
+	})
+	return &p, nil
+}
+
+// LoadLifecyclePolicyFile loads an ECR lifecycle policy document from the file.
+func LoadLifecyclePolicyFile(path string) (*LifecyclePolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseLifecyclePolicy(b)
+}
+
+// selects reports whether the rule selects the image.
+func (r *LifecyclePolicyRule) selects(d ecrTypes.ImageDetail) bool {
+	switch r.Selection.TagStatus {
+	case lifecycleTagStatusAny:
+		return true
+	case lifecycleTagStatusUntagged:
+		return len(d.ImageTags) == 0
+	}
+	if len(d.ImageTags) == 0 {
+		return false
+	}
+	// all of the prefixes and patterns must be matched by any of the tags
+	for _, prefix := range r.Selection.TagPrefixList {
+		if !containsTag(d.ImageTags, func(tag string) bool { return strings.HasPrefix(tag, prefix) }) {
+			return false
+		}
+	}
+	for _, pattern := range r.Selection.TagPatternList {
+		if !containsTag(d.ImageTags, func(tag string) bool { return wildcard.MatchSimple(pattern, tag) }) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsTag(tags []string, fn func(string) bool) bool {
+	for _, tag := range tags {
+		if fn(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// expiredImages evaluates the lifecycle policy against the images locally,
+// and returns a map of image digests to the rule priority that expires the image.
+func (p *LifecyclePolicy) expiredImages(images []ecrTypes.ImageDetail, now time.Time) map[string]int {
+	expired := make(map[string]int)
+	selected := newSet()
+	for _, r := range p.Rules {
+		// each image is selected by the rule with the highest priority (lowest number) only
+		var candidates []ecrTypes.ImageDetail
+		for _, d := range images {
+			digest := aws.ToString(d.ImageDigest)
+			if selected.contains(digest) || !r.selects(d) {
+				continue
+			}
+			selected.add(digest)
+			candidates = append(candidates, d)
+		}
+		if r.Action.Type != lifecycleActionExpire {
+			continue
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].ImagePushedAt.After(*candidates[j].ImagePushedAt)
+		})
+		for i, d := range candidates {
+			switch r.Selection.CountType {
+			case lifecycleCountTypeImageCountMoreThan:
+				if int64(i) < r.Selection.CountNumber {
+					continue
+				}
+			case lifecycleCountTypeSinceImagePushed:
+				if !d.ImagePushedAt.Before(now.AddDate(0, 0, -int(r.Selection.CountNumber))) {
+					continue
+				}
+			}
+			expired[aws.ToString(d.ImageDigest)] = r.RulePriority
+		}
+	}
+	return expired
+}
+
+// toRepositoryConfig translates the lifecycle policy into an equivalent repository config.
+// The translation is not always exact, so it returns warnings about the differences.
+func (p *LifecyclePolicy) toRepositoryConfig(name RepositoryName) (*RepositoryConfig, []string) {
+	rc := &RepositoryConfig{Name: name}
+	var warnings []string
+	var expiresDays, keepCount int64
+	var anyRule, taggedRule bool
+	var keepTagPatterns []string
+	for _, r := range p.Rules {
+		if r.Action.Type != lifecycleActionExpire {
+			warnings = append(warnings, fmt.Sprintf("rule %d: action %s is not supported, ignored", r.RulePriority, r.Action.Type))
+			continue
+		}
+		sel := r.Selection
+		switch sel.TagStatus {
+		case lifecycleTagStatusAny:
+			anyRule = true
+		case lifecycleTagStatusTagged:
+			taggedRule = true
+			if len(sel.TagPrefixList)+len(sel.TagPatternList) > 1 {
+				warnings = append(warnings, fmt.Sprintf("rule %d: images matched by any of the tag prefixes or patterns are selected, not all of them", r.RulePriority))
+			}
+			for _, prefix := range sel.TagPrefixList {
+				keepTagPatterns = append(keepTagPatterns, negatePrefix+prefix+"*")
+			}
+			for _, pattern := range sel.TagPatternList {
+				keepTagPatterns = append(keepTagPatterns, negatePrefix+pattern)
+			}
+		}
+		switch sel.CountType {
+		case lifecycleCountTypeSinceImagePushed:
+			if expiresDays == 0 || sel.CountNumber < expiresDays {
+				expiresDays = sel.CountNumber
+			}
+		case lifecycleCountTypeImageCountMoreThan:
+			if sel.TagStatus == lifecycleTagStatusUntagged {
+				warnings = append(warnings, fmt.Sprintf("rule %d: keep_count is not applied to untagged images, expires is applied instead", r.RulePriority))
+				continue
+			}
+			if keepCount != 0 && keepCount != sel.CountNumber {
+				warnings = append(warnings, fmt.Sprintf("rule %d: multiple imageCountMoreThan rules are merged into the largest keep_count", r.RulePriority))
+			}
+			if sel.CountNumber > keepCount {
+				keepCount = sel.CountNumber
+			}
+		}
+	}
+
+	if expiresDays == 0 {
+		warnings = append(warnings, "no sinceImagePushed rule, expires is set to 1d to expire images over keep_count")
+		expiresDays = 1
+	}
+	rc.Expires = fmt.Sprintf("%dd", expiresDays)
+	rc.KeepCount = keepCount
+
+	switch {
+	case anyRule:
+		// all images are subject to expiration. keep_tag_patterns are not needed.
+		if taggedRule {
+			warnings = append(warnings, "tagged rules are merged into the rule for any images")
+		}
+	case taggedRule:
+		// tags that are not selected by any rules are never expired by ECR
+		rc.KeepTagPatterns = keepTagPatterns
+	default:
+		// only untagged images are expired by ECR
+		rc.KeepTagPatterns = []string{"*"}
+	}
+	return rc, warnings
+}
+
+// ImportLifecyclePolicies reads lifecycle policies of the repositories and writes equivalent repository configs.
+func (app *App) ImportLifecyclePolicies(ctx context.Context, opt *Option) error {
+	planner := NewPlanner(app.awsCfg, app.clientOptions...)
+	rcs, err := planner.importLifecyclePolicies(ctx, opt.Repository)
+	if err != nil {
+		return err
+	}
+	w, err := opt.OutputWriter()
+	if err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
+	defer w.Close()
+	return yaml.NewEncoder(w, yaml.IndentSequence(true)).Encode(Config{Repositories: rcs})
+}
+
+func (p *Planner) importLifecyclePolicies(ctx context.Context, repo RepositoryName) ([]*RepositoryConfig, error) {
+	names, err := p.repositoryNames(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	var rcs []*RepositoryConfig
+	for _, name := range names {
+		policy, err := getLifecyclePolicy(ctx, p.ecr, name)
+		if err != nil {
+			return nil, err
+		}
+		if policy == nil {
+			log.Printf("[info] %s has no lifecycle policy", name)
+			continue
+		}
+		rc, warnings := policy.toRepositoryConfig(name)
+		for _, w := range warnings {
+			log.Printf("[warn] %s: %s", name, w)
+		}
+		log.Printf("[info] imported lifecycle policy of %s", name)
+		rcs = append(rcs, rc)
+	}
+	return rcs, nil
+}
+
+// CompareLifecyclePolicy evaluates ECR lifecycle policies locally and compares the result with the plan of ecrm.
+//
+// If policy is nil, the lifecycle policy of each repository is used. Repositories without a lifecycle policy are skipped.
+func (p *Planner) CompareLifecyclePolicy(ctx context.Context, c *Config, keepImages Images, repo RepositoryName, policy *LifecyclePolicy) (LifecycleComparisonTable, error) {
+	var table LifecycleComparisonTable
+	repos, err := p.repositories(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, r := range repos {
+		name := RepositoryName(aws.ToString(r.RepositoryName))
+		pol := policy
+		if pol == nil {
+			if pol, err = getLifecyclePolicy(ctx, p.ecr, name); err != nil {
+				return nil, err
+			} else if pol == nil {
+				log.Printf("[debug] %s has no lifecycle policy, skipped", name)
+				continue
+			}
+		}
+		imgs, err := p.listImageDetails(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		// compare with the config used by plan, including the overrides by tags
+		rc, _, err := p.repositoryConfig(ctx, c, r)
+		if err != nil {
+			log.Printf("[error] %s is skipped: %s", name, err)
+			continue
+		}
+		ecrmExpired := newSet()
+		if rc != nil {
+			ids, _, err := p.unusedImageIdentifiers(ctx, name, rc, keepImages, imgs)
+			if err != nil {
+				return nil, fmt.Errorf("failed to find unused image identifiers: %w", err)
+			}
+			for _, id := range ids {
+				ecrmExpired.add(aws.ToString(id.ImageDigest))
+			}
+		}
+
+		all := imgs.all()
+		ecrExpired := pol.expiredImages(all, now)
+		lc := &LifecycleComparison{Repo: name, TotalImages: int64(len(all))}
+		for _, d := range all {
+			digest := aws.ToString(d.ImageDigest)
+			priority, byECR := ecrExpired[digest]
+			byEcrm := ecrmExpired.contains(digest)
+			if byECR {
+				lc.ECRExpiredImages++
+			}
+			if byEcrm {
+				lc.EcrmExpiredImages++
+			}
+			if byECR && !byEcrm {
+				lc.ECROnlyImages++
+			}
+			if byEcrm && !byECR {
+				lc.EcrmOnlyImages++
+			}
+			if byECR && p.isInUse(d, keepImages) {
+				tag, _ := imageTag(d)
+				ref := string(name) + ":" + tag + "@" + digest
+				log.Printf("[warn] image %s is in use, but lifecycle policy rule %d expires it", ref, priority)
+				lc.InUseExpiredByECR = append(lc.InUseExpiredByECR, ref)
+			}
+		}
+		table = append(table, lc)
+	}
+	return table, nil
+}
+
+// LifecycleComparison is a result of comparison between an ECR lifecycle policy and ecrm for a repository.
+type LifecycleComparison struct {
+	Repo              RepositoryName `json:"repository"`
+	TotalImages       int64          `json:"total_images"`
+	ECRExpiredImages  int64          `json:"ecr_expired_images"`
+	EcrmExpiredImages int64          `json:"ecrm_expired_images"`
+	ECROnlyImages     int64          `json:"ecr_only_expired_images"`
+	EcrmOnlyImages    int64          `json:"ecrm_only_expired_images"`
+	InUseExpiredByECR []string       `json:"in_use_expired_by_ecr"`
+}
+
+func (c *LifecycleComparison) row() []string {
+	return []string{
+		string(c.Repo),
+		fmt.Sprintf("%d", c.TotalImages),
+		fmt.Sprintf("%d", -c.ECRExpiredImages),
+		fmt.Sprintf("%d", -c.EcrmExpiredImages),
+		fmt.Sprintf("%d", c.ECROnlyImages),
+		fmt.Sprintf("%d", c.EcrmOnlyImages),
+		fmt.Sprintf("%d", len(c.InUseExpiredByECR)),
+	}
+}
+
+type LifecycleComparisonTable []*LifecycleComparison
+
+func (t LifecycleComparisonTable) Print(w io.Writer, format outputFormat) error {
+	switch format {
+	case formatTable:
+		return t.printTable(w)
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(t)
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+func (t LifecycleComparisonTable) printTable(w io.Writer) error {
+	tw := tablewriter.NewWriter(w)
+	tw.SetHeader([]string{
+		"repository",
+		"total",
+		"expired (ECR)",
+		"expired (ecrm)",
+		"ECR only",
+		"ecrm only",
+		"in use (ECR expires)",
+	})
+	tw.SetBorder(false)
+	for _, c := range t {
+		row := c.row()
+		colors := make([]tablewriter.Colors, len(row))
+		if len(c.InUseExpiredByECR) > 0 {
+			colors[6] = tablewriter.Colors{tablewriter.FgRedColor, tablewriter.Bold}
+		}
+		if color.NoColor {
+			tw.Append(row)
+		} else {
+			tw.Rich(row, colors)
+		}
+	}
+	tw.Render()
+	return nil
+}
+
+// getLifecyclePolicy returns the lifecycle policy of the repository. It returns nil if the repository has no lifecycle policy.
+func getLifecyclePolicy(ctx context.Context, client ECRClient, repo RepositoryName) (*LifecyclePolicy, error) {
+	out, err := client.GetLifecyclePolicy(ctx, &ecr.GetLifecyclePolicyInput{
+		RepositoryName: aws.String(string(repo)),
+	})
+	if err != nil {
+		var notFound *ecrTypes.LifecyclePolicyNotFoundException
+		if errors.As(err, &notFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get lifecycle policy of %s: %w", repo, err)
+	}
+	policy, err := ParseLifecyclePolicy([]byte(aws.ToString(out.LifecyclePolicyText)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid lifecycle policy of %s: %w", repo, err)
+	}
+	return policy, nil
+}
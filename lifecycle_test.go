@@ -0,0 +1,123 @@
+package ecrm_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/fujiwara/ecrm"
+	"github.com/goccy/go-yaml"
+	"github.com/google/go-cmp/cmp"
+)
+
+func testImageDetail(digest string, pushedAt time.Time, tags ...string) ecrTypes.ImageDetail {
+	return ecrTypes.ImageDetail{
+		ImageDigest:   aws.String(digest),
+		ImagePushedAt: aws.Time(pushedAt),
+		ImageTags:     tags,
+	}
+}
+
+func TestLifecyclePolicyExpiredImages(t *testing.T) {
+	b, err := os.ReadFile("testdata/lifecycle-policy.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := ecrm.ParseLifecyclePolicy(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+	images := []ecrTypes.ImageDetail{
+		testImageDetail("sha256:r4", now.AddDate(0, 0, -1), "release-4"),
+		testImageDetail("sha256:r3", now.AddDate(0, 0, -2), "release-3", "latest"),
+		testImageDetail("sha256:r2", now.AddDate(0, 0, -3), "release-2"),
+		testImageDetail("sha256:r1", now.AddDate(0, 0, -100), "release-1"),
+		testImageDetail("sha256:d1", now.AddDate(0, 0, -100), "dev-1"),
+		testImageDetail("sha256:u1", now.AddDate(0, 0, -6)),
+		testImageDetail("sha256:u2", now.AddDate(0, 0, -8)),
+	}
+	expired := ecrm.LifecycleExpiredImages(policy, images, now)
+	if diff := cmp.Diff(map[string]int{
+		"sha256:r2": 2,
+		"sha256:r1": 2,
+		"sha256:u2": 1,
+	}, expired); diff != "" {
+		t.Errorf("unexpected expired images: %s", diff)
+	}
+}
+
+func TestLifecyclePolicyToRepositoryConfig(t *testing.T) {
+	policy, err := ecrm.LoadLifecyclePolicyFile("testdata/lifecycle-policy.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, warnings := ecrm.LifecycleToRepositoryConfig(policy, "foo/bar")
+	t.Log(warnings)
+	if diff := cmp.Diff(&ecrm.RepositoryConfig{
+		Name:            "foo/bar",
+		Expires:         "7d",
+		KeepCount:       2,
+		KeepTagPatterns: []string{"!release-*"},
+	}, rc, cmp.AllowUnexported(ecrm.RepositoryConfig{})); diff != "" {
+		t.Errorf("unexpected repository config: %s", diff)
+	}
+
+	// the negated pattern must be kept on YAML round trip
+	b := &bytes.Buffer{}
+	if err := yaml.NewEncoder(b).Encode(rc); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `"!release-*"`) {
+		t.Errorf("negated pattern is not quoted: %s", b.String())
+	}
+	var restored ecrm.RepositoryConfig
+	if err := yaml.Unmarshal(b.Bytes(), &restored); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompareLifecyclePolicyRepositoryTags(t *testing.T) {
+	f := newFakeAWS()
+	pushedAt := time.Now().Add(-20 * 24 * time.Hour)
+	for _, tag := range []string{"v1", "v2", "v3"} {
+		f.addImage("app", tag, pushedAt, tag)
+	}
+	f.repositories["app"].tags = map[string]string{"ecrm:expires": "7d"}
+	policy, err := ecrm.LoadLifecyclePolicyFile("testdata/lifecycle-policy.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	planner := ecrm.NewPlanner(testAWSConfig(), f.clientOptions()...)
+
+	for _, tc := range []struct {
+		repositoryTags bool
+		expired        int64
+	}{
+		{repositoryTags: false, expired: 0}, // expires 30d by the config
+		{repositoryTags: true, expired: 2},  // expires 7d by the tag, and keep_count 1
+	} {
+		c := &ecrm.Config{
+			RepositoryTags: tc.repositoryTags,
+			Repositories:   []*ecrm.RepositoryConfig{{NamePattern: "*", Expires: "30d", KeepCount: 1}},
+		}
+		if err := c.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		table, err := planner.CompareLifecyclePolicy(context.Background(), c, ecrm.Images{}, "", policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(table) != 1 || table[0].EcrmExpiredImages != tc.expired {
+			t.Errorf("repository_tags:%v unexpected comparison: %#v", tc.repositoryTags, table)
+		}
+	}
+}
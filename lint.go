@@ -0,0 +1,218 @@
+package ecrm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	lambdaTypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/goccy/go-yaml"
+	"github.com/k1LoW/duration"
+	"github.com/samber/lo"
+)
+
+const (
+	LintLevelError   = "error"
+	LintLevelWarning = "warning"
+)
+
+// ShortExpires is a threshold of suspiciously short expires of repositories.
+var ShortExpires = 7 * 24 * time.Hour
+
+// LintIssue is an issue found by the config lint.
+type LintIssue struct {
+	Level   string `json:"level"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+func (i *LintIssue) String() string {
+	if i.Path == "" {
+		return fmt.Sprintf("%s: %s", i.Level, i.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", i.Level, i.Path, i.Message)
+}
+
+// LintResult is a result of the config lint.
+type LintResult struct {
+	Config string       `json:"config"`
+	Valid  bool         `json:"valid"`
+	Issues []*LintIssue `json:"issues"`
+
+	config *Config
+}
+
+func (r *LintResult) add(level, path, format string, args ...any) {
+	r.Issues = append(r.Issues, &LintIssue{
+		Level:   level,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// count returns the number of issues at the level.
+func (r *LintResult) count(level string) int {
+	return lo.CountBy(r.Issues, func(i *LintIssue) bool { return i.Level == level })
+}
+
+func (r *LintResult) Print(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	default:
+		for _, i := range r.Issues {
+			fmt.Fprintf(w, "%s: %s\n", r.Config, i)
+		}
+		fmt.Fprintf(w, "%s: %d errors, %d warnings\n", r.Config, r.count(LintLevelError), r.count(LintLevelWarning))
+		return nil
+	}
+}
+
+// LintConfig checks the config file statically.
+func LintConfig(ctx context.Context, path string, opt *ConfigOption) *LintResult {
+	r := &LintResult{Config: path, Issues: []*LintIssue{}}
+	defer func() {
+		r.Valid = r.count(LintLevelError) == 0
+	}()
+
+	c, err := loadConfigFile(ctx, path, opt, nil, yaml.Strict())
+	if err != nil {
+		msg := yaml.FormatError(err, false, true)
+		if p := includedPath(err); p != "" {
+			msg = fmt.Sprintf("include %s: %s", p, msg)
+		}
+		r.add(LintLevelError, "", "%s", msg)
+		// continue to lint with the lenient parser
+		if c, err = loadConfigFile(ctx, path, opt, nil); err != nil {
+			return r
+		}
+	}
+
+	r.lintStatic(c)
+	// the warnings of Validate are reported as the issues
+	warn := func(path, format string, args ...any) {
+		r.add(LintLevelWarning, path, format, args...)
+	}
+	if err := c.validate(warn); err != nil {
+		r.add(LintLevelError, "", "%s", err)
+		return r
+	}
+	r.config = c
+	return r
+}
+
+// lintStatic checks the config before Validate() fills the default values.
+// The warnings of Validate() are not checked here.
+func (r *LintResult) lintStatic(c *Config) {
+	for _, m := range shadowedEntries("clusters", lo.Map(c.Clusters, func(cc *ClusterConfig, _ int) nameSpec { return cc.nameSpec() }), false) {
+		r.add(LintLevelError, "clusters", "%s", m)
+	}
+	for _, m := range shadowedEntries("task_definitions", lo.Map(c.TaskDefinitions, func(tc *TaskdefConfig, _ int) nameSpec { return tc.nameSpec() }), false) {
+		r.add(LintLevelError, "task_definitions", "%s", m)
+	}
+	for _, m := range shadowedEntries("lambda_functions", lo.Map(c.LambdaFunctions, func(lc *LambdaConfig, _ int) nameSpec { return lc.nameSpec() }), false) {
+		r.add(LintLevelError, "lambda_functions", "%s", m)
+	}
+	for _, m := range c.shadowedRepositories() {
+		r.add(LintLevelError, "repositories", "%s", m)
+	}
+
+	for i, rc := range c.Repositories {
+		path := fmt.Sprintf("repositories[%d]", i)
+		if rc.KeepCount == 0 {
+			r.add(LintLevelWarning, path, "keep_count is not defined or 0. no tagged images are kept by count after expires")
+		}
+		if rc.Expires == "" {
+			continue
+		}
+		if d, err := duration.Parse(rc.Expires); err == nil && d < ShortExpires {
+			r.add(LintLevelWarning, path, "expires %s is shorter than %d days", rc.Expires, int(ShortExpires.Hours()/24))
+		}
+	}
+}
+
+// lintAWS checks that every entry in the config matches at least one resource in the AWS account.
+// The clients of opts are used instead of the clients created from awsCfg.
+func (r *LintResult) lintAWS(ctx context.Context, awsCfg aws.Config, opts ...ClientOption) error {
+	c := r.config
+	if c == nil {
+		return nil
+	}
+	log.Println("[info] checking resources in the AWS account")
+	cs := newClientsWithConfig(awsCfg, opts)
+
+	clusters, err := clusterArns(ctx, cs.ecs)
+	if err != nil {
+		return fmt.Errorf("failed to list clusters: %w", err)
+	}
+	for i, cc := range c.Clusters {
+		if !lo.ContainsBy(clusters, cc.Match) {
+			r.add(LintLevelError, fmt.Sprintf("clusters[%d]", i), "%s matches no clusters", cc.nameSpec())
+		}
+	}
+
+	families, err := taskDefinitionFamilies(ctx, cs.ecs)
+	if err != nil {
+		return fmt.Errorf("failed to list task definition families: %w", err)
+	}
+	for i, tc := range c.TaskDefinitions {
+		if !lo.ContainsBy(families, tc.Match) {
+			r.add(LintLevelError, fmt.Sprintf("task_definitions[%d]", i), "%s matches no task definitions", tc.nameSpec())
+		}
+	}
+
+	fns, err := lambdaFunctions(ctx, cs.lambda)
+	if err != nil {
+		return fmt.Errorf("failed to list lambda functions: %w", err)
+	}
+	fnNames := lo.Map(fns, func(fn lambdaTypes.FunctionConfiguration, _ int) string { return aws.ToString(fn.FunctionName) })
+	for i, lc := range c.LambdaFunctions {
+		if !lo.ContainsBy(fnNames, lc.Match) {
+			r.add(LintLevelError, fmt.Sprintf("lambda_functions[%d]", i), "%s matches no Lambda functions", lc.nameSpec())
+		}
+	}
+
+	repos, err := ecrRepositories(ctx, cs.ecr)
+	if err != nil {
+		return fmt.Errorf("failed to list repositories: %w", err)
+	}
+	for i, rc := range c.Repositories {
+		if !lo.ContainsBy(repos, func(repo ecrTypes.Repository) bool {
+			return rc.MatchName(RepositoryName(aws.ToString(repo.RepositoryName)))
+		}) {
+			r.add(LintLevelError, fmt.Sprintf("repositories[%d]", i), "%s matches no repositories", rc.nameSpec())
+		}
+	}
+	return nil
+}
+
+// ValidateConfig validates the config file and prints the result.
+func (app *App) ValidateConfig(ctx context.Context, path string, opt *ValidateOption) error {
+	r := LintConfig(ctx, path, app.configOption)
+	if opt.CheckAWS {
+		if err := r.lintAWS(ctx, app.awsCfg, app.clientOptions...); err != nil {
+			return err
+		}
+	}
+	r.Valid = r.count(LintLevelError) == 0 && (!opt.Strict || r.count(LintLevelWarning) == 0)
+
+	w, err := opt.OutputWriter()
+	if err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
+	defer w.Close()
+	if err := r.Print(w, opt.Format); err != nil {
+		return err
+	}
+	if !r.Valid {
+		return fmt.Errorf("%s is invalid", path)
+	}
+	return nil
+}
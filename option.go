@@ -1,9 +1,14 @@
 package ecrm
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/k1LoW/duration"
 )
 
 type Option struct {
@@ -15,15 +20,59 @@ type Option struct {
 	OutputFile   string
 	Format       outputFormat
 	ScannedFiles []string
+	MaxScanAge   string // the scanned files must be newer than this duration. empty is no limit.
+	Parallelism  int    // the number of concurrent API calls. 0 is DefaultParallelism.
+	KeepGoing    bool   // skip repositories failed to plan or delete, and process the others.
+
+	CompareLifecycle    bool
+	LifecyclePolicyFile string
+
+	// SSEKMSKeyID is a KMS key ID to encrypt the output to S3. empty is the default encryption of the bucket.
+	SSEKMSKeyID string
+
+	// SignatureOption signs the scan result, and verifies the scanned files.
+	SignatureOption
+
+	// AWSConfig is used to read and write the files in S3.
+	AWSConfig *aws.Config
 }
 
 func (opt *Option) Validate() error {
 	if len(opt.ScannedFiles) == 0 && !opt.Scan {
 		return fmt.Errorf("no --scanned-files and --no-scan provided. specify at least one")
 	}
+	if _, err := opt.maxScanAge(); err != nil {
+		return err
+	}
+	if opt.Parallelism < 0 {
+		return fmt.Errorf("parallelism must not be negative: %d", opt.Parallelism)
+	}
 	return nil
 }
 
+// maxScanAge returns the max age of the scanned files. 0 is no limit.
+func (opt *Option) maxScanAge() (time.Duration, error) {
+	if opt.MaxScanAge == "" {
+		return 0, nil
+	}
+	d, err := duration.Parse(opt.MaxScanAge)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max-scan-age %s: %w", opt.MaxScanAge, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("max-scan-age must be positive: %s", opt.MaxScanAge)
+	}
+	return d, nil
+}
+
+// parallelism returns the number of concurrent API calls.
+func (opt *Option) parallelism() int {
+	if opt.Parallelism > 0 {
+		return opt.Parallelism
+	}
+	return DefaultParallelism
+}
+
 // NopCloserWriter is a writer that does nothing on Close
 type NopCloserWriter struct {
 	io.Writer
@@ -31,9 +80,114 @@ type NopCloserWriter struct {
 
 func (NopCloserWriter) Close() error { return nil }
 
+// OutputWriter returns a writer of the output file. s3://bucket/key is uploaded to S3 on Close.
 func (opt *Option) OutputWriter() (io.WriteCloser, error) {
-	if opt.OutputFile == "" || opt.OutputFile == "-" {
+	return outputWriterS3(opt.OutputFile, opt.AWSConfig, opt.SSEKMSKeyID)
+}
+
+// outputWriterS3 returns a writer of the output file, or a writer uploading to S3 on Close for s3://bucket/key.
+func outputWriterS3(name string, cfg *aws.Config, kmsKeyID string) (io.WriteCloser, error) {
+	if u, ok := parseS3URL(name); ok {
+		return newS3Writer(context.Background(), cfg, u, kmsKeyID)
+	}
+	return outputWriter(name)
+}
+
+func outputWriter(name string) (io.WriteCloser, error) {
+	if name == "" || name == "-" {
 		return NopCloserWriter{os.Stdout}, nil
 	}
-	return os.Create(opt.OutputFile)
+	return os.Create(name)
+}
+
+// ConfigOption is an option for loading the config file.
+type ConfigOption struct {
+	// ExtStr and ExtCode are external variables for Jsonnet.
+	ExtStr  map[string]string
+	ExtCode map[string]string
+
+	// AWSConfig is used to load the config from S3 or SSM Parameter Store.
+	AWSConfig *aws.Config
+}
+
+// GenerateOption is an option for the generate command.
+type GenerateOption struct {
+	Merge      bool
+	OutputFile string // "-" is STDOUT. The default is the config file.
+	Force      bool
+	Format     string // yaml or json
+
+	// values of the generated entries
+	KeepCount       int64
+	Expires         string
+	KeepTagPatterns []string
+
+	// pattern inference
+	MinGroupSize int  // minimum number of names to be grouped into a pattern
+	AnalyzeTags  bool // suggest keep_tag_patterns and semver by the existing tags of repositories
+
+	// retention analysis
+	Analyze      bool     // suggest expires and keep_count by the push history and images in use
+	ScannedFiles []string // files of the scan result, images in use in addition to the scan
+}
+
+func (opt *GenerateOption) Validate() error {
+	if opt.Merge && opt.Format == "json" {
+		return fmt.Errorf("--merge supports yaml format only")
+	}
+	if opt.KeepCount < 0 {
+		return fmt.Errorf("keep-count must not be negative")
+	}
+	if opt.MinGroupSize < 0 {
+		return fmt.Errorf("min-group-size must not be negative")
+	}
+	if _, err := duration.Parse(opt.Expires); err != nil {
+		return fmt.Errorf("invalid expires %s: %w", opt.Expires, err)
+	}
+	for _, pattern := range opt.KeepTagPatterns {
+		if err := validatePattern(pattern); err != nil {
+			return fmt.Errorf("invalid keep-tag-patterns %s: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func (opt *GenerateOption) output(configFile string) string {
+	if opt.OutputFile == "" {
+		return configFile
+	}
+	return opt.OutputFile
+}
+
+// ValidateOption is an option for the validate command.
+type ValidateOption struct {
+	OutputFile string
+	Format     string
+	CheckAWS   bool
+	Strict     bool
+}
+
+func (opt *ValidateOption) OutputWriter() (io.WriteCloser, error) {
+	return outputWriter(opt.OutputFile)
+}
+
+// MergeOption is an option for the merge command.
+type MergeOption struct {
+	Files       []string
+	OutputFile  string
+	SSEKMSKeyID string
+
+	// SignatureOption verifies the input files, and signs the merged output.
+	SignatureOption
+}
+
+// DiffOption is an option for the diff command.
+type DiffOption struct {
+	Old        string
+	New        string
+	OutputFile string
+	Format     string // text or json
+
+	// SignatureOption verifies the scanned files.
+	SignatureOption
 }
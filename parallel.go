@@ -0,0 +1,95 @@
+package ecrm
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+// DefaultParallelism is the default number of concurrent API calls.
+const DefaultParallelism = 4
+
+// maxRetryAttempts is the max attempts of an API call retried on throttling errors.
+const maxRetryAttempts = 10
+
+// parallel calls fn for each index in [0, n) with at most `parallelism` goroutines.
+// The first error cancels the context passed to the remaining calls and is returned.
+// Results should be stored by the index to keep the order deterministic.
+func parallel(ctx context.Context, parallelism, n int, fn func(ctx context.Context, i int) error) error {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	sem := make(chan struct{}, parallelism)
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			if err := fn(ctx, i); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// forEach calls fn for each index in [0, n) with at most `parallelism` goroutines.
+// Unlike parallel, an error does not stop the other calls. The errors are returned by the index.
+// When ctx is done, the remaining calls are not started and ctx.Err() is returned for them.
+func forEach(ctx context.Context, parallelism, n int, fn func(ctx context.Context, i int) error) []error {
+	errs := make([]error, n)
+	sem := make(chan struct{}, max(parallelism, 1))
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if err := ctx.Err(); err != nil {
+			for j := i; j < n; j++ {
+				errs[j] = err
+			}
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			errs[i] = fn(ctx, i)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// adaptiveRetryer returns a retryer that backs off and limits the request rate on throttling errors.
+// The retryer should be shared by concurrent calls to the same service to adapt to its rate limit.
+func adaptiveRetryer() aws.Retryer {
+	return retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+		o.StandardOptions = append(o.StandardOptions, func(so *retry.StandardOptions) {
+			so.MaxAttempts = maxRetryAttempts
+		})
+	})
+}
@@ -0,0 +1,177 @@
+package ecrm_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParallel(t *testing.T) {
+	ctx := context.Background()
+	var running, maxRunning atomic.Int32
+	results := make([]int, 20)
+	err := ecrm.Parallel(ctx, 3, len(results), func(ctx context.Context, i int) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		results[i] = i * i
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := maxRunning.Load(); m > 3 {
+		t.Errorf("too many concurrent calls: %d", m)
+	}
+	for i, r := range results {
+		if r != i*i {
+			t.Errorf("unexpected result[%d]: %d", i, r)
+		}
+	}
+}
+
+func TestParallelError(t *testing.T) {
+	ctx := context.Background()
+	errFailed := errors.New("failed")
+	var called atomic.Int32
+	err := ecrm.Parallel(ctx, 2, 100, func(ctx context.Context, i int) error {
+		called.Add(1)
+		if i == 3 {
+			return errFailed
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+			return nil
+		}
+	})
+	if !errors.Is(err, errFailed) {
+		t.Errorf("expected the first error, got %v", err)
+	}
+	if n := called.Load(); n >= 100 {
+		t.Errorf("remaining calls are not canceled: %d calls", n)
+	}
+}
+
+func TestScannerAddUsage(t *testing.T) {
+	var uris []ecrm.ImageURI
+	for i := 0; i < 50; i++ {
+		uris = append(uris, ecrm.ImageURI(fmt.Sprintf("012345678901.dkr.ecr.us-east-1.amazonaws.com/app:v%d", i)))
+	}
+	by := []ecrm.UsedBy{
+		{ARN: "arn:aws:lambda:us-east-1:012345678901:function:a", Kind: ecrm.UsedByLambdaFunction},
+		{ARN: "arn:aws:lambda:us-east-1:012345678901:function:b", Kind: ecrm.UsedByLambdaFunction},
+		{ARN: "arn:aws:lambda:us-east-1:012345678901:function:a", Kind: ecrm.UsedByLambdaFunction}, // duplicated
+	}
+	scanner := ecrm.NewScanner(testAWSConfig())
+	var wg sync.WaitGroup
+	for _, u := range uris {
+		for _, b := range by {
+			wg.Add(1)
+			go func(u ecrm.ImageURI, b ecrm.UsedBy) {
+				defer wg.Done()
+				scanner.AddUsage(u, b)
+				scanner.Images() // snapshots are taken while adding
+			}(u, b)
+		}
+	}
+	wg.Wait()
+	images := scanner.Images()
+	if len(images) != len(uris) {
+		t.Errorf("unexpected images: %d", len(images))
+	}
+	for _, u := range uris {
+		if diff := cmp.Diff(by[:2], images.UsedBy(u)); diff != "" {
+			t.Errorf("unexpected used_by of %s (-want +got):\n%s", u, diff)
+		}
+	}
+}
+
+func TestScannerImagesSnapshot(t *testing.T) {
+	u := ecrm.ImageURI("012345678901.dkr.ecr.us-east-1.amazonaws.com/app:latest")
+	by := ecrm.UsedBy{ARN: "arn:aws:lambda:us-east-1:012345678901:function:a", Kind: ecrm.UsedByLambdaFunction}
+	scanner := ecrm.NewScanner(testAWSConfig())
+	if !scanner.AddUsage(u, by) {
+		t.Error("the usage must be added")
+	}
+
+	// the snapshot does not change the scanner
+	images := scanner.Images()
+	images.AddUsage(u, ecrm.UsedBy{ARN: "arn:aws:lambda:us-east-1:012345678901:function:b", Kind: ecrm.UsedByLambdaFunction})
+	images.AddUsage(ecrm.ImageURI("012345678901.dkr.ecr.us-east-1.amazonaws.com/app:v1"), by)
+	got := scanner.Images()
+	if len(got) != 1 {
+		t.Errorf("unexpected images: %v", got)
+	}
+	if diff := cmp.Diff([]ecrm.UsedBy{by}, got.UsedBy(u)); diff != "" {
+		t.Errorf("unexpected used_by (-want +got):\n%s", diff)
+	}
+}
+
+func TestForEach(t *testing.T) {
+	ctx := context.Background()
+	errFailed := errors.New("failed")
+	var called atomic.Int32
+	errs := ecrm.ForEach(ctx, 3, 10, func(ctx context.Context, i int) error {
+		called.Add(1)
+		if i%4 == 1 {
+			return fmt.Errorf("repo-%d: %w", i, errFailed)
+		}
+		return nil
+	})
+	if n := called.Load(); n != 10 {
+		t.Errorf("an error must not stop the other calls: %d calls", n)
+	}
+	var failed []int
+	for i, err := range errs {
+		if err != nil {
+			if !errors.Is(err, errFailed) {
+				t.Errorf("unexpected error: %s", err)
+			}
+			failed = append(failed, i)
+		}
+	}
+	if diff := cmp.Diff([]int{1, 5, 9}, failed); diff != "" {
+		t.Errorf("unexpected failed indexes (-want +got):\n%s", diff)
+	}
+}
+
+func TestForEachCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var called atomic.Int32
+	errs := ecrm.ForEach(ctx, 2, 10, func(ctx context.Context, i int) error {
+		if called.Add(1) == 2 {
+			cancel()
+		}
+		<-ctx.Done()
+		return nil
+	})
+	if n := called.Load(); n != 2 {
+		t.Errorf("no calls must be started after canceled: %d calls", n)
+	}
+	for i, err := range errs[:2] {
+		if err != nil {
+			t.Errorf("unexpected error of the started call %d: %s", i, err)
+		}
+	}
+	for i, err := range errs[2:] {
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled for the skipped call %d, got %v", i+2, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package ecrm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/fujiwara/ecrm/wildcard"
+)
+
+const negatePrefix = "!"
+
+// matchPattern reports whether the name matches the wildcard pattern.
+// The pattern prefixed with "!" is negated.
+func matchPattern(pattern, name string) bool {
+	if p, negated := strings.CutPrefix(pattern, negatePrefix); negated {
+		return !wildcard.Match(p, name)
+	}
+	return wildcard.Match(pattern, name)
+}
+
+func validatePattern(pattern string) error {
+	if pattern == negatePrefix {
+		return errors.New("negated pattern must not be empty")
+	}
+	return nil
+}
+
+// matchPatterns reports whether the name matches the patterns.
+// The name matches when it matches any of the positive patterns and none of the negated patterns.
+// When all patterns are negated, the name matches unless it matches any of them.
+func matchPatterns(patterns []string, name string) bool {
+	var positive, matched bool
+	for _, pattern := range patterns {
+		if p, negated := strings.CutPrefix(pattern, negatePrefix); negated {
+			if wildcard.Match(p, name) {
+				return false
+			}
+			continue
+		}
+		positive = true
+		if wildcard.Match(pattern, name) {
+			matched = true
+		}
+	}
+	if !positive {
+		return len(patterns) > 0
+	}
+	return matched
+}
+
+// patternRegexp is a compiled regular expression which may be negated by the "!" prefix.
+type patternRegexp struct {
+	re      *regexp.Regexp
+	negated bool
+}
+
+func compilePatternRegexp(s string) (*patternRegexp, error) {
+	if s == "" {
+		return nil, nil
+	}
+	expr, negated := strings.CutPrefix(s, negatePrefix)
+	if expr == "" {
+		return nil, errors.New("negated regexp must not be empty")
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regexp %q: %w", s, err)
+	}
+	return &patternRegexp{re: re, negated: negated}, nil
+}
+
+func (p *patternRegexp) match(s string) bool {
+	if p == nil {
+		return false
+	}
+	return p.re.MatchString(s) != p.negated
+}
+
+// exclusiveNames returns an error if more than one of name, name_pattern and name_regexp are defined.
+func exclusiveNames(section string, names ...string) error {
+	var n int
+	for _, name := range names {
+		if name != "" {
+			n++
+		}
+	}
+	if n > 1 {
+		return fmt.Errorf("%s name, name_pattern and name_regexp are exclusive", section)
+	}
+	return nil
+}
+
+// nameSpec is a set of name, name_pattern and name_regexp of a config entry.
+type nameSpec struct {
+	name    string
+	pattern string
+	regexp  string
+}
+
+func (n nameSpec) String() string {
+	switch {
+	case n.name != "":
+		return "name:" + n.name
+	case n.pattern != "":
+		return "name_pattern:" + n.pattern
+	default:
+		return "name_regexp:" + n.regexp
+	}
+}
+
+// shadows reports whether all names matched by o are also matched by n.
+// It is conservative; it may return false for complex patterns even if n shadows o.
+func (n nameSpec) shadows(o nameSpec) bool {
+	if n == o {
+		return true
+	}
+	if n.regexp != "" || o.regexp != "" {
+		return false
+	}
+	if strings.HasPrefix(n.pattern, negatePrefix) || strings.HasPrefix(o.pattern, negatePrefix) {
+		return false
+	}
+	switch {
+	case n.name != "":
+		return o.name == n.name
+	case o.name != "":
+		return wildcard.Match(n.pattern, o.name)
+	default:
+		return patternCovers([]rune(n.pattern), []rune(o.pattern))
+	}
+}
+
+// patternCovers reports whether the wildcard pattern p matches all names matched by the wildcard pattern q.
+func patternCovers(p, q []rune) bool {
+	if len(p) == 0 {
+		return len(q) == 0
+	}
+	switch p[0] {
+	case '*':
+		return patternCovers(p[1:], q) || (len(q) > 0 && patternCovers(p, q[1:]))
+	case '?':
+		return len(q) > 0 && q[0] != '*' && patternCovers(p[1:], q[1:])
+	default:
+		return len(q) > 0 && q[0] == p[0] && patternCovers(p[1:], q[1:])
+	}
+}
+
+// specificity returns how specific the name spec is. The higher is the more specific.
+// An exact name is the most specific, then a wildcard pattern with more literal characters.
+// Negated patterns, regexps and "*" are the least specific.
+func (n nameSpec) specificity() int {
+	switch {
+	case n.name != "":
+		return math.MaxInt
+	case n.regexp != "" || strings.HasPrefix(n.pattern, negatePrefix):
+		return 0
+	}
+	return len(strings.Map(func(r rune) rune {
+		if r == '*' || r == '?' {
+			return -1
+		}
+		return r
+	}, n.pattern))
+}
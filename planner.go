@@ -18,14 +18,36 @@ import (
 )
 
 type Planner struct {
-	ecr    *ecr.Client
+	ecr    ECRClient
 	region string
+
+	// parallelism is the number of repositories planned concurrently.
+	parallelism int
+}
+
+// NewPlanner creates a planner. The ECR client of opts is used instead of the client created from cfg.
+func NewPlanner(cfg aws.Config, opts ...ClientOption) *Planner {
+	p := &Planner{
+		ecr:         newClients(opts).ecr,
+		region:      cfg.Region,
+		parallelism: DefaultParallelism,
+	}
+	if p.ecr == nil {
+		p.ecr = newECRClient(cfg)
+	}
+	return p
 }
 
-func NewPlanner(cfg aws.Config) *Planner {
-	return &Planner{
-		ecr:    ecr.NewFromConfig(cfg),
-		region: cfg.Region,
+func newECRClient(cfg aws.Config) *ecr.Client {
+	return ecr.NewFromConfig(cfg, func(o *ecr.Options) {
+		o.Retryer = adaptiveRetryer()
+	})
+}
+
+// SetParallelism sets the number of repositories planned concurrently.
+func (p *Planner) SetParallelism(n int) {
+	if n > 0 {
+		p.parallelism = n
 	}
 }
 
@@ -43,54 +65,148 @@ func (d DeletableImageIDs) RepositoryNames() []RepositoryName {
 //
 // keepImages is a set of images in use by ECS tasks / task definitions / lambda functions
 // so that they are not deleted
-func (p *Planner) Plan(ctx context.Context, rcs []*RepositoryConfig, keepImages Images, repo RepositoryName) (SummaryTable, DeletableImageIDs, error) {
+//
+// Repositories are planned concurrently. A failure of a repository does not stop planning the others,
+// and the results of the other repositories are returned with RepositoryErrors.
+func (p *Planner) Plan(ctx context.Context, c *Config, keepImages Images, repo RepositoryName) (SummaryTable, DeletableImageIDs, error) {
+	repos, err := p.repositories(ctx, repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	type planResult struct {
+		sums     RepoSummary
+		imageIDs []ecrTypes.ImageIdentifier
+		planned  bool
+		invalid  bool
+	}
+	results := make([]planResult, len(repos))
+	errs := forEach(ctx, p.parallelism, len(repos), func(ctx context.Context, i int) error {
+		r := repos[i]
+		name := RepositoryName(aws.ToString(r.RepositoryName))
+		rc, overridden, err := p.repositoryConfig(ctx, c, r)
+		if err != nil {
+			log.Printf("[error] %s is skipped: %s", name, err)
+			results[i].invalid = true
+			return nil
+		}
+		if rc == nil {
+			return nil
+		}
+		imgs, err := p.listImageDetails(ctx, name)
+		if err != nil {
+			return err
+		}
+		imageIDs, sum, err := p.unusedImageIdentifiers(ctx, name, rc, keepImages, imgs)
+		if err != nil {
+			return fmt.Errorf("failed to find unused image identifiers: %w", err)
+		}
+		sum.SetConfig(rc, overridden)
+		results[i] = planResult{sums: sum, imageIDs: imageIDs, planned: true}
+		return nil
+	})
+
 	idsMaps := make(DeletableImageIDs)
 	sums := SummaryTable{}
+	var invalid int
+	var repoErrs RepositoryErrors
+	for i, r := range results {
+		name := RepositoryName(aws.ToString(repos[i].RepositoryName))
+		switch {
+		case errs[i] != nil:
+			repoErrs = append(repoErrs, &RepositoryError{Repository: name, Err: errs[i]})
+		case r.invalid:
+			invalid++
+		case r.planned:
+			sums = append(sums, r.sums...)
+			idsMaps[name] = r.imageIDs
+		}
+	}
+	if invalid > 0 {
+		log.Printf("[warn] %d repositories are skipped due to invalid repository tags", invalid)
+	}
+	sums.Sort()
+	if len(repoErrs) > 0 {
+		return sums, idsMaps, repoErrs
+	}
+	return sums, idsMaps, nil
+}
+
+// repositories returns the repositories. If repo is specified, returns only the repository.
+func (p *Planner) repositories(ctx context.Context, repo RepositoryName) ([]ecrTypes.Repository, error) {
 	in := &ecr.DescribeRepositoriesInput{}
 	if repo != "" {
 		in.RepositoryNames = []string{string(repo)}
 	}
+	var repos []ecrTypes.Repository
 	pager := ecr.NewDescribeRepositoriesPaginator(p.ecr, in)
 	for pager.HasMorePages() {
-		repos, err := pager.NextPage(ctx)
+		out, err := pager.NextPage(ctx)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to describe repositories: %w", err)
+			return nil, fmt.Errorf("failed to describe repositories: %w", err)
 		}
-	REPO:
-		for _, repo := range repos.Repositories {
-			name := RepositoryName(*repo.RepositoryName)
-			var rc *RepositoryConfig
-			for _, _rc := range rcs {
-				if _rc.MatchName(name) {
-					rc = _rc
-					break
-				}
-			}
-			if rc == nil {
-				continue REPO
-			}
-			imageIDs, sum, err := p.unusedImageIdentifiers(ctx, name, rc, keepImages)
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to find unused image identifiers: %w", err)
-			}
-			sums = append(sums, sum...)
-			idsMaps[name] = imageIDs
+		repos = append(repos, out.Repositories...)
+	}
+	return repos, nil
+}
+
+// repositoryNames returns names of the repositories. If repo is specified, returns only the repository.
+func (p *Planner) repositoryNames(ctx context.Context, repo RepositoryName) ([]RepositoryName, error) {
+	repos, err := p.repositories(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	return lo.Map(repos, func(r ecrTypes.Repository, _ int) RepositoryName {
+		return RepositoryName(aws.ToString(r.RepositoryName))
+	}), nil
+}
+
+// repositoryImages represents images in a repository classified by their types.
+type repositoryImages struct {
+	images       []ecrTypes.ImageDetail
+	imageIndexes []ecrTypes.ImageDetail
+	sociIndexes  []ecrTypes.ImageDetail
+	referrers    []ecrTypes.ImageDetail
+	idByTags     map[string]ecrTypes.ImageIdentifier
+
+	// subjects is a map of the referrer digests to the subject digests
+	subjects map[string]string
+}
+
+// all returns all images in the repository.
+func (ri *repositoryImages) all() []ecrTypes.ImageDetail {
+	all := make([]ecrTypes.ImageDetail, 0, len(ri.images)+len(ri.imageIndexes)+len(ri.sociIndexes)+len(ri.referrers))
+	all = append(all, ri.images...)
+	all = append(all, ri.imageIndexes...)
+	all = append(all, ri.sociIndexes...)
+	all = append(all, ri.referrers...)
+	return all
+}
+
+// isInUse reports whether the image is in use by digest or tags.
+func (p *Planner) isInUse(d ecrTypes.ImageDetail, keepImages Images) bool {
+	imageURISha256 := ImageURI(fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com/%s@%s", *d.RegistryId, p.region, *d.RepositoryName, *d.ImageDigest))
+	if keepImages.Contains(imageURISha256) {
+		return true
+	}
+	for _, tag := range d.ImageTags {
+		imageURI := ImageURI(fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com/%s:%s", *d.RegistryId, p.region, *d.RepositoryName, tag))
+		if keepImages.Contains(imageURI) {
+			return true
 		}
 	}
-	sums.Sort()
-	return sums, idsMaps, nil
+	return false
 }
 
 // unusedImageIdentifiers finds image identifiers(by image digests) from the repository.
-func (p *Planner) unusedImageIdentifiers(ctx context.Context, repo RepositoryName, rc *RepositoryConfig, keepImages Images) ([]ecrTypes.ImageIdentifier, RepoSummary, error) {
+func (p *Planner) unusedImageIdentifiers(ctx context.Context, repo RepositoryName, rc *RepositoryConfig, keepImages Images, imgs *repositoryImages) ([]ecrTypes.ImageIdentifier, RepoSummary, error) {
 	sums := NewRepoSummary(repo)
-	images, imageIndexes, sociIndexes, idByTags, err := p.listImageDetails(ctx, repo)
-	if err != nil {
-		return nil, sums, err
-	}
-	log.Printf("[info] %s has %d images, %d image indexes, %d soci indexes", repo, len(images), len(imageIndexes), len(sociIndexes))
+	images, imageIndexes, sociIndexes, idByTags := imgs.images, imgs.imageIndexes, imgs.sociIndexes, imgs.idByTags
+	log.Printf("[info] %s has %d images, %d image indexes, %d soci indexes, %d referrers", repo, len(images), len(imageIndexes), len(sociIndexes), len(imgs.referrers))
 	expiredIds := make([]ecrTypes.ImageIdentifier, 0)
 	expiredImageIndexes := newSet()
+	semverTags := rc.Semver.keepTags(lo.FlatMap(images, func(d ecrTypes.ImageDetail, _ int) []string {
+		return d.ImageTags
+	}))
 	var keepCount int64
 IMAGE:
 	for _, d := range images {
@@ -120,6 +236,14 @@ IMAGE:
 			}
 		}
 
+		// Check if the image is kept by semver rule
+		for _, tag := range d.ImageTags {
+			if semverTags.contains(tag) {
+				log.Printf("[info] image %s:%s is matched by semver condition, keep it", repo, tag)
+				continue IMAGE
+			}
+		}
+
 		// Check if the image is expired
 		pushedAt := *d.ImagePushedAt
 		if !rc.IsExpired(pushedAt) {
@@ -179,11 +303,51 @@ SOCI_INDEX:
 		}
 	}
 
+	expiredIds = append(expiredIds, expireReferrers(repo, imgs, expiredIds, sums)...)
+
 	return expiredIds, sums, nil
 }
 
-func (p *Planner) listImageDetails(ctx context.Context, repo RepositoryName) ([]ecrTypes.ImageDetail, []ecrTypes.ImageDetail, []ecrTypes.ImageDetail, map[string]ecrTypes.ImageIdentifier, error) {
-	var images, imageIndexes, sociIndexes []ecrTypes.ImageDetail
+// expireReferrers finds referrers whose subjects are expired. Referrers are kept while their subjects are kept.
+func expireReferrers(repo RepositoryName, imgs *repositoryImages, expiredIds []ecrTypes.ImageIdentifier, sums RepoSummary) []ecrTypes.ImageIdentifier {
+	expired := newSet()
+	for _, id := range expiredIds {
+		expired.add(aws.ToString(id.ImageDigest))
+	}
+	exists := newSet()
+	for _, d := range imgs.all() {
+		exists.add(aws.ToString(d.ImageDigest))
+	}
+	for _, d := range imgs.referrers {
+		sums.AddReferrer(d)
+		if subject := imgs.subjects[aws.ToString(d.ImageDigest)]; !exists.contains(subject) {
+			log.Printf("[info] subject %s of referrer %s@%s is not found, keep it", subject, repo, *d.ImageDigest)
+		}
+	}
+
+	// referrers may refer to other referrers (e.g. a signature of a SBOM), so repeat until no more referrers are expired
+	ids := make([]ecrTypes.ImageIdentifier, 0)
+	for {
+		var found bool
+		for _, d := range imgs.referrers {
+			digest := aws.ToString(d.ImageDigest)
+			if expired.contains(digest) || !expired.contains(imgs.subjects[digest]) {
+				continue
+			}
+			log.Printf("[notice] %s@%s is expired (referrer of %s)", repo, digest, imgs.subjects[digest])
+			expired.add(digest)
+			sums.ExpireReferrer(d)
+			ids = append(ids, ecrTypes.ImageIdentifier{ImageDigest: d.ImageDigest})
+			found = true
+		}
+		if !found {
+			return ids
+		}
+	}
+}
+
+func (p *Planner) listImageDetails(ctx context.Context, repo RepositoryName) (*repositoryImages, error) {
+	var images, imageIndexes, sociIndexes, artifacts []ecrTypes.ImageDetail
 	foundTags := make(map[string]ecrTypes.ImageIdentifier, 0)
 
 	pager := ecr.NewDescribeImagesPaginator(p.ecr, &ecr.DescribeImagesInput{
@@ -192,15 +356,21 @@ func (p *Planner) listImageDetails(ctx context.Context, repo RepositoryName) ([]
 	for pager.HasMorePages() {
 		imgs, err := pager.NextPage(ctx)
 		if err != nil {
-			return nil, nil, nil, nil, fmt.Errorf("failed to describe images: %w", err)
+			return nil, fmt.Errorf("failed to describe images: %w", err)
 		}
 		for _, img := range imgs.ImageDetails {
-			if isContainerImage(img) {
+			if _, ok := referrerSubjectByTags(img.ImageTags); ok {
+				// cosign signatures, attestations and SBOMs look like container images.
+				// they are not counted as images for keep_count.
+				artifacts = append(artifacts, img)
+			} else if isContainerImage(img) {
 				images = append(images, img)
 			} else if isImageIndex(img) {
 				imageIndexes = append(imageIndexes, img)
 			} else if isSociIndex(img) {
 				sociIndexes = append(sociIndexes, img)
+			} else {
+				artifacts = append(artifacts, img)
 			}
 			for _, tag := range img.ImageTags {
 				foundTags[tag] = ecrTypes.ImageIdentifier{ImageDigest: img.ImageDigest}
@@ -217,7 +387,27 @@ func (p *Planner) listImageDetails(ctx context.Context, repo RepositoryName) ([]
 	sort.SliceStable(sociIndexes, func(i, j int) bool {
 		return sociIndexes[i].ImagePushedAt.After(*sociIndexes[j].ImagePushedAt)
 	})
-	return images, imageIndexes, sociIndexes, foundTags, nil
+
+	subjects, err := p.findReferrerSubjects(ctx, repo, artifacts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find referrer subjects: %w", err)
+	}
+	referrers := lo.Filter(artifacts, func(d ecrTypes.ImageDetail, _ int) bool {
+		_, ok := subjects[aws.ToString(d.ImageDigest)]
+		return ok
+	})
+	sort.SliceStable(referrers, func(i, j int) bool {
+		return referrers[i].ImagePushedAt.After(*referrers[j].ImagePushedAt)
+	})
+
+	return &repositoryImages{
+		images:       images,
+		imageIndexes: imageIndexes,
+		sociIndexes:  sociIndexes,
+		referrers:    referrers,
+		idByTags:     foundTags,
+		subjects:     subjects,
+	}, nil
 }
 
 func (p *Planner) findSociIndex(ctx context.Context, repo RepositoryName, imageTags []string) ([]ecrTypes.ImageIdentifier, error) {
@@ -0,0 +1,100 @@
+package ecrm_test
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+)
+
+// newReferrerTagsFakeAWS returns a fake backend with the repository "app" below, and the names of the digests.
+//
+//	v1, v2, v3:  expired images, v3 is the newest
+//	v1.sig:      signature of v1 tagged sha256-{v1}.sig
+//	v2.att:      attestation of v2 tagged sha256-{v2}.att
+//	v3.sbom:     SBOM of v3 tagged sha256-{v3}.sbom, pushed after all of the images
+//	index:       image index tagged sha256-{v1}, expired with v1
+//	index.sig:   signature of the image index tagged sha256-{index}.sig
+//	missing.sig: signature of the missing image
+func newReferrerTagsFakeAWS() (*fakeAWS, map[string]string) {
+	f := newFakeAWS()
+	old := time.Now().Add(-60 * 24 * time.Hour)
+	digests := map[string]string{}
+	referrerTag := func(name, suffix string) string {
+		return strings.Replace(digests[name], "sha256:", "sha256-", 1) + "." + suffix
+	}
+
+	digests["v1"] = f.addImage("app", "v1", old, "v1")
+	digests["v2"] = f.addImage("app", "v2", old.Add(time.Hour), "v2")
+	digests["v3"] = f.addImage("app", "v3", old.Add(2*time.Hour), "v3")
+	digests["index"] = f.addImageIndex("app", "index", old, []string{digests["v1"]}, strings.Replace(digests["v1"], "sha256:", "sha256-", 1))
+	digests["v1.sig"] = f.addImage("app", "v1.sig", old.Add(3*time.Hour), referrerTag("v1", "sig"))
+	digests["v2.att"] = f.addImage("app", "v2.att", old.Add(3*time.Hour), referrerTag("v2", "att"))
+	digests["v3.sbom"] = f.addImage("app", "v3.sbom", old.Add(3*time.Hour), referrerTag("v3", "sbom"))
+	digests["index.sig"] = f.addImage("app", "index.sig", old.Add(3*time.Hour), referrerTag("index", "sig"))
+	digests["missing.sig"] = f.addImage("app", "missing.sig", old.Add(3*time.Hour), strings.Replace(fakeDigest("missing"), "sha256:", "sha256-", 1)+".sig")
+	return f, digests
+}
+
+func TestPlanReferrerTags(t *testing.T) {
+	cases := []struct {
+		name      string
+		keepCount int64
+		inUse     []string
+		expect    []string
+	}{
+		{
+			name:   "referrers are deleted with the subjects",
+			expect: []string{"index", "index.sig", "v1", "v1.sig", "v2", "v2.att", "v3", "v3.sbom"},
+		},
+		{
+			name:      "referrers are not counted by keep_count",
+			keepCount: 1,
+			expect:    []string{"index", "index.sig", "v1", "v1.sig", "v2", "v2.att"},
+		},
+		{
+			name:      "referrers are kept with the subjects in use",
+			keepCount: 1,
+			inUse:     []string{"v1"},
+			expect:    []string{"v2", "v2.att"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f, digests := newReferrerTagsFakeAWS()
+			planner := ecrm.NewPlanner(testAWSConfig(), f.clientOptions()...)
+			config := &ecrm.Config{
+				Repositories: []*ecrm.RepositoryConfig{{Name: "app", Expires: "30d", KeepCount: c.keepCount}},
+			}
+			if err := config.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			keep := make(ecrm.Images)
+			for _, name := range c.inUse {
+				keep.Add(fakeImageURI("app", name), "test")
+			}
+			_, candidates, err := planner.Plan(context.Background(), config, keep, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var deleted []string
+			for _, id := range candidates["app"] {
+				for name, digest := range digests {
+					if digest == aws.ToString(id.ImageDigest) {
+						deleted = append(deleted, name)
+					}
+				}
+			}
+			sort.Strings(deleted)
+			// the referrer of the missing subject is always kept
+			if diff := cmp.Diff(c.expect, deleted); diff != "" {
+				t.Errorf("unexpected deleted images (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
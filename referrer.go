@@ -0,0 +1,78 @@
+package ecrm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	oci "github.com/google/go-containerregistry/pkg/v1"
+	ociTypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/samber/lo"
+)
+
+// referrerTagRe matches tags of referrer artifacts by the tag schema.
+// e.g. sha256-{digest of the subject}.sig
+var referrerTagRe = regexp.MustCompile(`^sha256-([0-9a-f]{64})\.(sig|att|sbom)$`)
+
+// referrerSubjectByTags returns the digest of the subject by the tags of the referrer.
+func referrerSubjectByTags(tags []string) (string, bool) {
+	for _, tag := range tags {
+		if m := referrerTagRe.FindStringSubmatch(tag); m != nil {
+			return "sha256:" + m[1], true
+		}
+	}
+	return "", false
+}
+
+// findReferrerSubjects finds subjects of the artifacts by tags or the subject field of the manifests.
+// It returns a map of the referrer digests to the subject digests.
+func (p *Planner) findReferrerSubjects(ctx context.Context, repo RepositoryName, artifacts []ecrTypes.ImageDetail) (map[string]string, error) {
+	subjects := make(map[string]string, len(artifacts))
+	var digests []string
+	for _, d := range artifacts {
+		if subject, ok := referrerSubjectByTags(d.ImageTags); ok {
+			subjects[aws.ToString(d.ImageDigest)] = subject
+			continue
+		}
+		digests = append(digests, aws.ToString(d.ImageDigest))
+	}
+
+	for _, c := range lo.Chunk(digests, batchGetImageLimit) {
+		imageIds := make([]ecrTypes.ImageIdentifier, 0, len(c))
+		for _, digest := range c {
+			imageIds = append(imageIds, ecrTypes.ImageIdentifier{ImageDigest: aws.String(digest)})
+		}
+		res, err := p.ecr.BatchGetImage(ctx, &ecr.BatchGetImageInput{
+			ImageIds:       imageIds,
+			RepositoryName: aws.String(string(repo)),
+			AcceptedMediaTypes: []string{
+				string(ociTypes.OCIManifestSchema1),
+				string(ociTypes.DockerManifestSchema2),
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to batch get image: %w", err)
+		}
+		for _, img := range res.Images {
+			if img.ImageManifest == nil {
+				continue
+			}
+			var m oci.Manifest
+			if err := json.Unmarshal([]byte(*img.ImageManifest), &m); err != nil {
+				log.Printf("[warn] failed to parse manifest: %s %s", *img.ImageManifest, err)
+				continue
+			}
+			if m.Subject == nil {
+				log.Printf("[debug] %s@%s has no subject", repo, aws.ToString(img.ImageId.ImageDigest))
+				continue
+			}
+			subjects[aws.ToString(img.ImageId.ImageDigest)] = m.Subject.Digest.String()
+		}
+	}
+	return subjects, nil
+}
@@ -0,0 +1,118 @@
+package ecrm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// Tags of ECR repositories to override the repositories configurations.
+const (
+	RepositoryTagPrefix          = "ecrm:"
+	RepositoryTagExpires         = RepositoryTagPrefix + "expires"
+	RepositoryTagKeepCount       = RepositoryTagPrefix + "keep_count"
+	RepositoryTagKeepTagPatterns = RepositoryTagPrefix + "keep_tag_patterns"
+	RepositoryTagOptOut          = RepositoryTagPrefix + "opt-out"
+)
+
+// repositoryConfig returns the effective config of the repository, and names of the fields overridden by the tags.
+// The tags are applied only when repository_tags is enabled. It returns nil if the repository is not managed by ecrm.
+func (p *Planner) repositoryConfig(ctx context.Context, c *Config, repo ecrTypes.Repository) (*RepositoryConfig, []string, error) {
+	rc := c.RepositoryConfig(RepositoryName(aws.ToString(repo.RepositoryName)))
+	if !c.RepositoryTags {
+		return rc, nil, nil
+	}
+	return p.applyRepositoryTags(ctx, repo, rc, c.RepositoryTagsOptIn)
+}
+
+// applyRepositoryTags returns the repository config overridden by the tags of the repository, and names of the overridden fields.
+//
+// Precedence: ecrm:opt-out > tags > the matched repository config.
+// It returns nil if the repository is opted out, or no repository config matches and the repository is not opted in
+// (optIn is false or the repository has no ecrm:expires tag).
+func (p *Planner) applyRepositoryTags(ctx context.Context, repo ecrTypes.Repository, rc *RepositoryConfig, optIn bool) (*RepositoryConfig, []string, error) {
+	out, err := p.ecr.ListTagsForResource(ctx, &ecr.ListTagsForResourceInput{
+		ResourceArn: repo.RepositoryArn,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	tags := make(map[string]string, len(out.Tags))
+	for _, t := range out.Tags {
+		tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	return overrideByRepositoryTags(RepositoryName(aws.ToString(repo.RepositoryName)), rc, tags, optIn)
+}
+
+func overrideByRepositoryTags(name RepositoryName, rc *RepositoryConfig, tags map[string]string, optIn bool) (*RepositoryConfig, []string, error) {
+	if v, ok := tags[RepositoryTagOptOut]; ok {
+		optOut, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid tag %s=%s: %w", RepositoryTagOptOut, v, err)
+		}
+		if optOut {
+			log.Printf("[info] %s is opted out by the tag %s", name, RepositoryTagOptOut)
+			return nil, nil, nil
+		}
+	}
+
+	var n RepositoryConfig
+	if rc != nil {
+		n = *rc
+		n.KeepTagPatterns = append([]string{}, rc.KeepTagPatterns...)
+	} else if _, ok := tags[RepositoryTagExpires]; ok && optIn {
+		n = RepositoryConfig{KeepCount: int64(DefaultKeepCount)}
+	} else {
+		if ok {
+			log.Printf("[warn] %s has the tag %s but matches no repositories entry. set repository_tags_opt_in: true to manage it by the tags", name, RepositoryTagExpires)
+		}
+		return nil, nil, nil
+	}
+	n.Name, n.NamePattern, n.NameRegexp = name, "", ""
+
+	var overridden []string
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := tags[k]
+		switch k {
+		case RepositoryTagExpires:
+			n.Expires = v
+		case RepositoryTagKeepCount:
+			c, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || c < 0 {
+				return nil, nil, fmt.Errorf("invalid tag %s=%s: must be a non-negative integer", k, v)
+			}
+			n.KeepCount = c
+		case RepositoryTagKeepTagPatterns:
+			// tag values cannot contain commas, so patterns are separated by spaces
+			n.KeepTagPatterns = strings.Fields(v)
+		case RepositoryTagOptOut:
+			continue
+		default:
+			if strings.HasPrefix(k, RepositoryTagPrefix) {
+				return nil, nil, fmt.Errorf("unknown tag %s", k)
+			}
+			continue
+		}
+		overridden = append(overridden, strings.TrimPrefix(k, RepositoryTagPrefix))
+	}
+	if len(overridden) == 0 {
+		return rc, nil, nil
+	}
+	if err := n.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid repository tags: %w", err)
+	}
+	log.Printf("[info] %s config is overridden by tags: %s", name, strings.Join(overridden, ", "))
+	return &n, overridden, nil
+}
@@ -0,0 +1,103 @@
+package ecrm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// parseS3URL parses s3://bucket/key. It returns false if the name is not a S3 URL.
+func parseS3URL(name string) (*url.URL, bool) {
+	u, err := url.Parse(name)
+	if err != nil || u.Scheme != configSchemeS3 {
+		return nil, false
+	}
+	return u, true
+}
+
+// s3Writer is a writer that uploads the written content to S3 on Close.
+type s3Writer struct {
+	bytes.Buffer
+	ctx      context.Context
+	client   *s3.Client
+	u        *url.URL
+	kmsKeyID string
+}
+
+func newS3Writer(ctx context.Context, cfg *aws.Config, u *url.URL, kmsKeyID string) (*s3Writer, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("AWS config is required to write %s", u)
+	}
+	if u.Host == "" || strings.Trim(u.Path, "/") == "" || strings.HasSuffix(u.Path, "/") {
+		return nil, fmt.Errorf("invalid S3 URL %s. must be s3://bucket/key", u)
+	}
+	return &s3Writer{
+		ctx:      ctx,
+		client:   s3Client(*cfg),
+		u:        u,
+		kmsKeyID: kmsKeyID,
+	}, nil
+}
+
+func (w *s3Writer) Close() error {
+	in := &s3.PutObjectInput{
+		Bucket:      aws.String(w.u.Host),
+		Key:         aws.String(strings.TrimPrefix(w.u.Path, "/")),
+		Body:        bytes.NewReader(w.Bytes()),
+		ContentType: aws.String("application/json"),
+	}
+	if w.kmsKeyID != "" {
+		in.ServerSideEncryption = s3Types.ServerSideEncryptionAwsKms
+		in.SSEKMSKeyId = aws.String(w.kmsKeyID)
+	}
+	if _, err := w.client.PutObject(w.ctx, in); err != nil {
+		return fmt.Errorf("failed to put %s: %w", w.u, err)
+	}
+	log.Println("[info] uploaded", w.u)
+	return nil
+}
+
+// expandS3URL returns URLs of the objects under the prefix if the URL ends with "/".
+// Otherwise, it returns the URL itself.
+// The set of the URLs that have the detached signatures (URL.sig) under the prefix is also returned.
+func expandS3URL(ctx context.Context, client *s3.Client, u *url.URL) ([]*url.URL, set, error) {
+	signed := newSet()
+	if !strings.HasSuffix(u.Path, "/") && u.Path != "" {
+		return []*url.URL{u}, signed, nil
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	var urls []*url.URL
+	pager := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(u.Host),
+		Prefix: aws.String(prefix),
+	})
+	for pager.HasMorePages() {
+		out, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list objects in %s: %w", u, err)
+		}
+		for _, obj := range out.Contents {
+			key := aws.ToString(obj.Key)
+			if strings.HasSuffix(key, "/") {
+				continue // directory placeholder
+			}
+			if strings.HasSuffix(key, SignatureSuffix) {
+				// detached signature of the scanned file
+				signed.add((&url.URL{Scheme: configSchemeS3, Host: u.Host, Path: "/" + strings.TrimSuffix(key, SignatureSuffix)}).String())
+				continue
+			}
+			urls = append(urls, &url.URL{Scheme: configSchemeS3, Host: u.Host, Path: "/" + key})
+		}
+	}
+	if len(urls) == 0 {
+		return nil, nil, fmt.Errorf("no objects found in %s", u)
+	}
+	return urls, signed, nil
+}
@@ -0,0 +1,137 @@
+package ecrm_test
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+)
+
+// s3StandIn is a local stand-in of S3 supporting path-style PutObject, GetObject and ListObjectsV2.
+type s3StandIn struct {
+	mu      sync.Mutex
+	objects map[string][]byte // "/bucket/key"
+	headers map[string]http.Header
+	gets    []string // paths of GetObject requests
+}
+
+type listBucketResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Name        string   `xml:"Name"`
+	Prefix      string   `xml:"Prefix"`
+	KeyCount    int      `xml:"KeyCount"`
+	IsTruncated bool     `xml:"IsTruncated"`
+	Contents    []struct {
+		Key  string `xml:"Key"`
+		Size int    `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPut:
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.objects[r.URL.Path] = b
+		s.headers[r.URL.Path] = r.Header.Clone()
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		bucket := strings.Trim(r.URL.Path, "/")
+		res := listBucketResult{Name: bucket, Prefix: r.URL.Query().Get("prefix")}
+		var keys []string
+		for k := range s.objects {
+			if key, ok := strings.CutPrefix(k, "/"+bucket+"/"); ok && strings.HasPrefix(key, res.Prefix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			res.Contents = append(res.Contents, struct {
+				Key  string `xml:"Key"`
+				Size int    `xml:"Size"`
+			}{Key: key, Size: len(s.objects["/"+bucket+"/"+key])})
+		}
+		res.KeyCount = len(keys)
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(res)
+	case r.Method == http.MethodGet:
+		s.gets = append(s.gets, r.URL.Path)
+		b, ok := s.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code></Error>`))
+			return
+		}
+		w.Write(b)
+	default:
+		http.Error(w, "unsupported", http.StatusBadRequest)
+	}
+}
+
+func TestScannedFilesInS3(t *testing.T) {
+	standIn := &s3StandIn{objects: map[string][]byte{}, headers: map[string]http.Header{}}
+	ts := httptest.NewServer(standIn)
+	defer ts.Close()
+	ctx := context.Background()
+	cfg := testRemoteConfigOption(ts.URL).AWSConfig
+
+	for _, account := range []string{"111111111111", "222222222222"} {
+		scanner := ecrm.NewScanner(*cfg)
+		scanner.AddUsage(ecrm.ImageURI(account+".dkr.ecr.us-east-1.amazonaws.com/app:latest"), ecrm.UsedBy{
+			ARN:  "arn:aws:lambda:us-east-1:" + account + ":function:app",
+			Kind: ecrm.UsedByLambdaFunction,
+		})
+		err := ecrm.ShowScanResult(scanner, &ecrm.Option{
+			OutputFile:  "s3://scans/ecrm/" + account + ".json",
+			SSEKMSKeyID: "alias/ecrm",
+			AWSConfig:   cfg,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := standIn.headers["/scans/ecrm/111111111111.json"]
+	if h.Get("X-Amz-Server-Side-Encryption") != "aws:kms" || h.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id") != "alias/ecrm" {
+		t.Errorf("unexpected SSE headers: %v", h)
+	}
+
+	scanner := ecrm.NewScanner(*cfg)
+	if err := scanner.LoadFiles(ctx, []string{"s3://scans/ecrm/"}); err != nil {
+		t.Fatal(err)
+	}
+	var uris []string
+	for u := range scanner.Images() {
+		uris = append(uris, u.String())
+	}
+	sort.Strings(uris)
+	if diff := cmp.Diff([]string{
+		"111111111111.dkr.ecr.us-east-1.amazonaws.com/app:latest",
+		"222222222222.dkr.ecr.us-east-1.amazonaws.com/app:latest",
+	}, uris); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+
+	scanner = ecrm.NewScanner(*cfg)
+	if err := scanner.LoadFiles(ctx, []string{"s3://scans/ecrm/111111111111.json"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(scanner.Images()) != 1 {
+		t.Errorf("unexpected images: %v", scanner.Images())
+	}
+
+	if err := scanner.LoadFiles(ctx, []string{"s3://scans/missing/"}); err == nil {
+		t.Error("expected error for empty prefix")
+	}
+}
@@ -0,0 +1,163 @@
+package ecrm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+
+	"github.com/fatih/color"
+)
+
+// MergeScannedFiles merges the scanned files into a scanned file.
+// Images are deduplicated, and the resources using them are preserved.
+func (app *App) MergeScannedFiles(ctx context.Context, opt *MergeOption) error {
+	scanner := NewScanner(app.awsCfg, app.clientOptions...)
+	var err error
+	if scanner.verifier, err = opt.verifier(&app.awsCfg); err != nil {
+		return fmt.Errorf("failed to load the verification key: %w", err)
+	}
+	if err := scanner.LoadFiles(ctx, opt.Files); err != nil {
+		return fmt.Errorf("failed to load scanned files: %w", err)
+	}
+	meta := mergeScanMetadata(scanner.files)
+	meta.ECRMVersion = app.Version
+
+	// the merged output is signed (when enabled) to be verified by plan and delete
+	w, err := signedOutputWriter(opt.OutputFile, &app.awsCfg, opt.SSEKMSKeyID, &opt.SignatureOption)
+	if err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
+	images := scanner.Images()
+	if err := images.PrintScanResult(w, meta); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	log.Printf("[info] merged %d image URIs from %d scanned files", len(images), len(scanner.files))
+	return nil
+}
+
+// mergeScanMetadata merges the metadata of the scanned files.
+// The merged scan is as old as the oldest file, not to hide stale files from --max-scan-age.
+// Account ID, region and config hash are kept only when all files have the same value.
+func mergeScanMetadata(files []scannedFile) ScanMetadata {
+	var meta ScanMetadata
+	for i, f := range files {
+		m := f.metadata
+		if i == 0 {
+			meta = m
+			continue
+		}
+		if m.ScannedAt.IsZero() || (!meta.ScannedAt.IsZero() && m.ScannedAt.Before(meta.ScannedAt)) {
+			meta.ScannedAt = m.ScannedAt
+		}
+		if meta.AccountID != m.AccountID {
+			meta.AccountID = ""
+		}
+		if meta.Region != m.Region {
+			meta.Region = ""
+		}
+		if meta.ConfigHash != m.ConfigHash {
+			meta.ConfigHash = ""
+		}
+	}
+	return meta
+}
+
+// RepositoryImagesDiff is a difference of the images in use in a repository between two scans.
+type RepositoryImagesDiff struct {
+	Repository string     `json:"repository"`
+	Added      []ImageURI `json:"added,omitempty"`
+	Removed    []ImageURI `json:"removed,omitempty"`
+}
+
+// diffImages returns image URIs appeared in and disappeared from the new scan, grouped by repository.
+func diffImages(oldImages, newImages Images) []*RepositoryImagesDiff {
+	diffs := make(map[string]*RepositoryImagesDiff)
+	get := func(u ImageURI) *RepositoryImagesDiff {
+		repo := u.Base()
+		if d, ok := diffs[repo]; ok {
+			return d
+		}
+		diffs[repo] = &RepositoryImagesDiff{Repository: repo}
+		return diffs[repo]
+	}
+	for u := range newImages {
+		if !oldImages.Contains(u) {
+			d := get(u)
+			d.Added = append(d.Added, u)
+		}
+	}
+	for u := range oldImages {
+		if !newImages.Contains(u) {
+			d := get(u)
+			d.Removed = append(d.Removed, u)
+		}
+	}
+	result := make([]*RepositoryImagesDiff, 0, len(diffs))
+	for _, d := range diffs {
+		sort.Slice(d.Added, func(i, j int) bool { return d.Added[i] < d.Added[j] })
+		sort.Slice(d.Removed, func(i, j int) bool { return d.Removed[i] < d.Removed[j] })
+		result = append(result, d)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Repository < result[j].Repository })
+	return result
+}
+
+// DiffScannedFiles shows the image URIs appeared or disappeared between two scanned files.
+func (app *App) DiffScannedFiles(ctx context.Context, opt *DiffOption) error {
+	v, err := opt.verifier(&app.awsCfg)
+	if err != nil {
+		return fmt.Errorf("failed to load the verification key: %w", err)
+	}
+	load := func(name string) (Images, error) {
+		scanner := NewScanner(app.awsCfg, app.clientOptions...)
+		scanner.verifier = v
+		if err := scanner.LoadFiles(ctx, []string{name}); err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", name, err)
+		}
+		return scanner.Images(), nil
+	}
+	oldImages, err := load(opt.Old)
+	if err != nil {
+		return err
+	}
+	newImages, err := load(opt.New)
+	if err != nil {
+		return err
+	}
+	diffs := diffImages(oldImages, newImages)
+
+	w, err := outputWriterS3(opt.OutputFile, &app.awsCfg, "")
+	if err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
+	if err := printImagesDiff(w, diffs, opt.Format); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func printImagesDiff(w io.Writer, diffs []*RepositoryImagesDiff, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diffs)
+	}
+	for _, d := range diffs {
+		fmt.Fprintf(w, "%s (+%d -%d)\n", d.Repository, len(d.Added), len(d.Removed))
+		for _, u := range d.Added {
+			fmt.Fprintln(w, color.GreenString("+ %s", u.Short()))
+		}
+		for _, u := range d.Removed {
+			fmt.Fprintln(w, color.RedString("- %s", u.Short()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package ecrm_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffImages(t *testing.T) {
+	oldImages := make(ecrm.Images)
+	oldImages.Add("0123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/foo/bar:v1", "old")
+	oldImages.Add("0123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/foo/bar:v2", "old")
+	oldImages.Add("0123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/foo/baz:v1", "old")
+	newImages := make(ecrm.Images)
+	newImages.Add("0123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/foo/bar:v2", "new")
+	newImages.Add("0123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/foo/bar:v3", "new")
+	newImages.Add("0123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/foo/qux:v1", "new")
+
+	expect := []*ecrm.RepositoryImagesDiff{
+		{
+			Repository: "0123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/foo/bar",
+			Added:      []ecrm.ImageURI{"0123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/foo/bar:v3"},
+			Removed:    []ecrm.ImageURI{"0123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/foo/bar:v1"},
+		},
+		{
+			Repository: "0123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/foo/baz",
+			Removed:    []ecrm.ImageURI{"0123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/foo/baz:v1"},
+		},
+		{
+			Repository: "0123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/foo/qux",
+			Added:      []ecrm.ImageURI{"0123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/foo/qux:v1"},
+		},
+	}
+	if diff := cmp.Diff(expect, ecrm.DiffImages(oldImages, newImages)); diff != "" {
+		t.Errorf("unexpected diff (-want +got):\n%s", diff)
+	}
+	if d := ecrm.DiffImages(newImages, newImages); len(d) != 0 {
+		t.Errorf("expected no diff: %v", d)
+	}
+}
+
+func TestMergeScanMetadata(t *testing.T) {
+	dir := t.TempDir()
+	newer := dir + "/newer.json"
+	if err := os.WriteFile(newer, []byte(`{
+  "version": 2,
+  "metadata": {"account_id": "0123456789012", "region": "us-east-1", "scanned_at": "2024-10-03T00:00:00Z"},
+  "images": []
+}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := ecrm.MergeScanMetadata([]string{newer, "testdata/images_v2.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := ecrm.ScanMetadata{
+		AccountID: "0123456789012",
+		ScannedAt: time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC), // the oldest
+	}
+	if diff := cmp.Diff(expect, meta); diff != "" {
+		t.Errorf("unexpected metadata (-want +got):\n%s", diff)
+	}
+
+	// format v1 has no timestamp, so the merged scan has no timestamp
+	meta, err = ecrm.MergeScanMetadata([]string{newer, "testdata/images.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.ScannedAt.IsZero() {
+		t.Errorf("unexpected scanned_at: %s", meta.ScannedAt)
+	}
+}
@@ -2,34 +2,106 @@ package ecrm
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
 	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/samber/lo"
 )
 
 type Scanner struct {
-	Images Images
+	Metadata ScanMetadata
 
-	ecs    *ecs.Client
-	lambda *lambda.Client
+	ecs    ECSClient
+	lambda LambdaClient
+	sts    STSClient
+	s3     *s3.Client
+	files  []scannedFile
+
+	// verifier verifies the signature of the scanned files on load if set.
+	verifier verifier
+
+	// parallelism is the number of concurrent API calls in Scan.
+	parallelism int
+	// images is the image URIs in use, updated by concurrent scanning under mu.
+	mu     sync.Mutex
+	images Images
+}
+
+// NewScanner creates a scanner. The clients of opts are used instead of the clients created from cfg.
+func NewScanner(cfg aws.Config, opts ...ClientOption) *Scanner {
+	s := &Scanner{
+		images:      make(Images),
+		Metadata:    ScanMetadata{Region: cfg.Region},
+		s3:          s3Client(cfg),
+		parallelism: DefaultParallelism,
+	}
+	cs := newClients(opts)
+	if s.ecs = cs.ecs; s.ecs == nil {
+		s.ecs = ecs.NewFromConfig(cfg, func(o *ecs.Options) {
+			o.Retryer = adaptiveRetryer()
+		})
+	}
+	if s.lambda = cs.lambda; s.lambda == nil {
+		s.lambda = lambda.NewFromConfig(cfg, func(o *lambda.Options) {
+			o.Retryer = adaptiveRetryer()
+		})
+	}
+	if s.sts = cs.sts; s.sts == nil {
+		s.sts = sts.NewFromConfig(cfg)
+	}
+	return s
 }
 
-func NewScanner(cfg aws.Config) *Scanner {
-	return &Scanner{
-		Images: make(Images),
-		ecs:    ecs.NewFromConfig(cfg),
-		lambda: lambda.NewFromConfig(cfg),
+// Images returns a snapshot of the image URIs in use. It is safe to call while scanning.
+func (s *Scanner) Images() Images {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	images := make(Images, len(s.images))
+	images.Merge(s.images)
+	return images
+}
+
+// AddUsage adds the image used by the resource. It returns false if the image is already used by the resource.
+// It is safe to call concurrently.
+func (s *Scanner) AddUsage(u ImageURI, by UsedBy) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.images.AddUsage(u, by)
+}
+
+// SetParallelism sets the number of concurrent API calls in Scan.
+func (s *Scanner) SetParallelism(n int) {
+	if n > 0 {
+		s.parallelism = n
 	}
 }
 
 func (s *Scanner) Scan(ctx context.Context, c *Config) error {
-	log.Println("[info] scanning resources")
+	log.Printf("[info] scanning resources (parallelism %d)", s.parallelism)
+	s.Metadata.ScannedAt = time.Now()
+	if h, err := configHash(c); err != nil {
+		return err
+	} else {
+		s.Metadata.ConfigHash = h
+	}
+	if res, err := s.sts.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{}); err != nil {
+		log.Printf("[warn] failed to get the account ID: %s", err)
+	} else {
+		s.Metadata.AccountID = aws.ToString(res.Account)
+	}
 
 	// collect images in use by ECS tasks / task definitions
 	var taskdefs []taskdef
@@ -55,55 +127,124 @@ func (s *Scanner) Scan(ctx context.Context, c *Config) error {
 	return nil
 }
 
-func (s *Scanner) LoadFiles(files []string) error {
+// LoadFiles loads the scanned files.
+// s3://bucket/key loads the object, and s3://bucket/prefix/ loads every object under the prefix.
+// If the verifier is set, the files are rejected unless the detached signatures (FILE.sig) are valid.
+func (s *Scanner) LoadFiles(ctx context.Context, files []string) error {
 	for _, f := range files {
+		if u, ok := parseS3URL(f); ok {
+			urls, signed, err := expandS3URL(ctx, s.s3, u)
+			if err != nil {
+				return err
+			}
+			for _, u := range urls {
+				log.Println("[info] loading scanned image URIs from", u)
+				read := func(u *url.URL) ([]byte, error) {
+					return fetchConfigFromS3(ctx, s.s3, u)
+				}
+				if err := s.loadFile(ctx, u.String(), u, read, signed.contains(u.String())); err != nil {
+					return err
+				}
+			}
+			continue
+		}
 		log.Println("[info] loading scanned image URIs from", f)
-		imgs := make(Images)
-		if err := imgs.LoadFile(f); err != nil {
+		read := func(u *url.URL) ([]byte, error) {
+			b, err := os.ReadFile(u.Path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open file: %w", err)
+			}
+			return b, nil
+		}
+		_, err := os.Stat(f + SignatureSuffix)
+		if err := s.loadFile(ctx, f, &url.URL{Path: f}, read, err == nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadFile loads the scanned file. signed reports whether the detached signature is known to exist,
+// to warn that it is not verified without the verifier. The signature is read only by the verifier.
+func (s *Scanner) loadFile(ctx context.Context, name string, u *url.URL, read func(*url.URL) ([]byte, error), signed bool) error {
+	b, err := read(u)
+	if err != nil {
+		return err
+	}
+	if s.verifier != nil {
+		sigURL := *u
+		sigURL.Path += SignatureSuffix
+		sig, err := read(&sigURL)
+		if err != nil {
+			return fmt.Errorf("failed to read the signature of %s: %w", name, err)
+		}
+		if err := verifyContent(ctx, s.verifier, name, b, sig); err != nil {
 			return err
 		}
-		log.Println("[info] loaded", len(imgs), "image URIs")
-		s.Images.Merge(imgs)
+	} else if signed {
+		log.Printf("[warn] %s is signed (%s), but not verified. specify --verify-key or --verify-kms-key-id to verify it", name, SignatureSuffix)
+	}
+	return s.addScanResult(name, b)
+}
+
+func (s *Scanner) addScanResult(name string, b []byte) error {
+	imgs := make(Images)
+	r, err := imgs.addScanResult(name, b)
+	if err != nil {
+		return err
 	}
+	log.Println("[info] loaded", len(imgs), "image URIs")
+	s.mu.Lock()
+	s.images.Merge(imgs)
+	s.mu.Unlock()
+	s.files = append(s.files, scannedFile{name: name, version: r.Version, metadata: r.Metadata})
 	return nil
 }
 
 func (s *Scanner) Save(w io.Writer) error {
 	log.Println("[info] saving scanned image URIs")
-	if err := s.Images.Print(w); err != nil {
+	images := s.Images()
+	if err := images.PrintScanResult(w, s.Metadata); err != nil {
 		return err
 	}
-	log.Println("[info] saved", len(s.Images), "image URIs")
+	log.Println("[info] saved", len(images), "image URIs")
 	return nil
 }
 
 // collectImages collects images in use by ECS tasks / task definitions
 func (s *Scanner) collectImages(ctx context.Context, taskdefs []taskdef) error {
 	dup := newSet()
+	var names []string
 	for _, td := range taskdefs {
-		tds := td.String()
-		if dup.contains(tds) {
-			continue
+		if tds := td.String(); dup.add(tds) {
+			names = append(names, tds)
 		}
-		dup.add(tds)
-
-		ids, err := s.extractECRImages(ctx, tds)
+	}
+	return parallel(ctx, s.parallelism, len(names), func(ctx context.Context, i int) error {
+		tds := names[i]
+		imgs, err := s.extractECRImages(ctx, tds)
 		if err != nil {
 			return err
 		}
-		for _, id := range ids {
-			if s.Images.Add(id, tds) {
-				log.Printf("[info] image %s is in use by taskdef %s", id.String(), tds)
+		for _, img := range imgs {
+			if s.AddUsage(img.uri, img.usedBy) {
+				log.Printf("[info] image %s is in use by taskdef %s", img.uri.String(), tds)
 			}
 		}
-	}
-	return nil
+		return nil
+	})
+}
+
+// containerImage is an image used by a container.
+type containerImage struct {
+	uri    ImageURI
+	usedBy UsedBy
 }
 
 // extractECRImages extracts images (only in ECR) from the task definition
-// returns image URIs
-func (s *Scanner) extractECRImages(ctx context.Context, tdName string) ([]ImageURI, error) {
-	images := make([]ImageURI, 0)
+// returns image URIs and the containers using them
+func (s *Scanner) extractECRImages(ctx context.Context, tdName string) ([]containerImage, error) {
+	images := make([]containerImage, 0)
 	out, err := s.ecs.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
 		TaskDefinition: &tdName,
 	})
@@ -113,7 +254,14 @@ func (s *Scanner) extractECRImages(ctx context.Context, tdName string) ([]ImageU
 	for _, container := range out.TaskDefinition.ContainerDefinitions {
 		u := ImageURI(*container.Image)
 		if u.IsECRImage() {
-			images = append(images, u)
+			images = append(images, containerImage{
+				uri: u,
+				usedBy: UsedBy{
+					ARN:       aws.ToString(out.TaskDefinition.TaskDefinitionArn),
+					Kind:      UsedByECSTaskDefinition,
+					Container: aws.ToString(container.Name),
+				},
+			})
 		} else {
 			log.Printf("[debug] Skipping non ECR image %s", u)
 		}
@@ -123,32 +271,28 @@ func (s *Scanner) extractECRImages(ctx context.Context, tdName string) ([]ImageU
 
 // scanClusters scans ECS clusters and returns task definitions and images in use
 func (s *Scanner) scanClusters(ctx context.Context, clustersConfigs []*ClusterConfig) ([]taskdef, error) {
-	tds := make([]taskdef, 0)
 	clusterArns, err := clusterArns(ctx, s.ecs)
 	if err != nil {
 		return nil, err
 	}
+	clusterArns = lo.Filter(clusterArns, func(a string, _ int) bool {
+		return lo.SomeBy(clustersConfigs, func(cc *ClusterConfig) bool { return cc.Match(a) })
+	})
 
-	for _, a := range clusterArns {
-		var clusterArn string
-		for _, cc := range clustersConfigs {
-			if cc.Match(a) {
-				clusterArn = a
-				break
-			}
-		}
-		if clusterArn == "" {
-			continue
-		}
-
-		log.Printf("[debug] Checking cluster %s", clusterArn)
-		if _tds, err := s.availableResourcesInCluster(ctx, clusterArn); err != nil {
-			return tds, err
-		} else {
-			tds = append(tds, _tds...)
+	results := make([][]taskdef, len(clusterArns))
+	err = parallel(ctx, s.parallelism, len(clusterArns), func(ctx context.Context, i int) error {
+		log.Printf("[debug] Checking cluster %s", clusterArns[i])
+		tds, err := s.availableResourcesInCluster(ctx, clusterArns[i])
+		if err != nil {
+			return err
 		}
+		results[i] = tds
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return tds, nil
+	return lo.Flatten(results), nil
 }
 
 // collectTaskdefs collects task definitions by configurations
@@ -159,19 +303,23 @@ func (s *Scanner) collectTaskdefs(ctx context.Context, tcs []*TaskdefConfig) ([]
 		return tds, err
 	}
 
+	type familyConfig struct {
+		name      string
+		keepCount int64
+	}
+	var targets []familyConfig
 	for _, family := range families {
-		var name string
-		var keepCount int64
 		for _, tc := range tcs {
 			if tc.Match(family) {
-				name = family
-				keepCount = tc.KeepCount
+				targets = append(targets, familyConfig{name: family, keepCount: tc.KeepCount})
 				break
 			}
 		}
-		if name == "" {
-			continue
-		}
+	}
+
+	results := make([][]taskdef, len(targets))
+	err = parallel(ctx, s.parallelism, len(targets), func(ctx context.Context, i int) error {
+		name, keepCount := targets[i].name, targets[i].keepCount
 		log.Printf("[debug] Checking task definitions %s latest %d revisions", name, keepCount)
 		res, err := s.ecs.ListTaskDefinitions(ctx, &ecs.ListTaskDefinitionsInput{
 			FamilyPrefix: &name,
@@ -179,17 +327,21 @@ func (s *Scanner) collectTaskdefs(ctx context.Context, tcs []*TaskdefConfig) ([]
 			Sort:         ecsTypes.SortOrderDesc,
 		})
 		if err != nil {
-			return tds, err
+			return err
 		}
 		for _, tdArn := range res.TaskDefinitionArns {
 			td, err := parseTaskdefArn(tdArn)
 			if err != nil {
-				return tds, err
+				return err
 			}
-			tds = append(tds, td)
+			results[i] = append(results[i], td)
 		}
+		return nil
+	})
+	if err != nil {
+		return tds, err
 	}
-	return tds, nil
+	return append(tds, lo.Flatten(results)...), nil
 }
 
 // availableResourcesInCluster scans task definitions and images in use in the cluster
@@ -242,15 +394,16 @@ func (s *Scanner) availableResourcesInCluster(ctx context.Context, clusterArn st
 					continue
 				}
 				// ECR image
+				usedBy := UsedBy{ARN: aws.ToString(task.TaskArn), Kind: UsedByECSTask, Container: aws.ToString(c.Name)}
 				if u.IsDigestURI() {
-					if s.Images.Add(u, tdArn) {
+					if s.AddUsage(u, usedBy) {
 						log.Printf("[info] image %s is used by %s container on %s", u.String(), *c.Name, ts.Resource)
 					}
 				} else if c.ImageDigest != nil {
 					base := u.Base()
 					digest := aws.ToString(c.ImageDigest)
 					u := ImageURI(base + "@" + digest)
-					if s.Images.Add(u, tdArn) {
+					if s.AddUsage(u, usedBy) {
 						log.Printf("[info] image %s is used by %s container on %s", u.String(), *c.Name, ts.Resource)
 					}
 				}
@@ -288,8 +441,10 @@ func (s *Scanner) availableResourcesInCluster(ctx context.Context, clusterArn st
 			}
 		}
 	}
+	arns := tdArns.members()
+	sort.Strings(arns) // deterministic order
 	var tds []taskdef
-	for a := range tdArns {
+	for _, a := range arns {
 		td, err := parseTaskdefArn(a)
 		if err != nil {
 			return nil, err
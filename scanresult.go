@@ -0,0 +1,159 @@
+package ecrm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+// ScanResultVersion is the current version of the scanned file format.
+const ScanResultVersion = 2
+
+// ScanResult is the scanned file format v2.
+//
+// The format v1 is a JSON array of image URIs. It is still supported by Images.LoadFile.
+type ScanResult struct {
+	Version  int            `json:"version"`
+	Metadata ScanMetadata   `json:"metadata"`
+	Images   []ScannedImage `json:"images"`
+}
+
+// ScanMetadata is metadata of the scan.
+type ScanMetadata struct {
+	AccountID   string    `json:"account_id,omitempty"`
+	Region      string    `json:"region,omitempty"`
+	ECRMVersion string    `json:"ecrm_version,omitempty"`
+	ScannedAt   time.Time `json:"scanned_at"`
+	ConfigHash  string    `json:"config_hash,omitempty"`
+}
+
+// ScannedImage is an image in use and the resources using it.
+type ScannedImage struct {
+	URI    ImageURI `json:"uri"`
+	UsedBy []UsedBy `json:"used_by"`
+}
+
+// PrintScanResult writes the images with the metadata as the scanned file format v2.
+func (i Images) PrintScanResult(w io.Writer, meta ScanMetadata) error {
+	r := ScanResult{
+		Version:  ScanResultVersion,
+		Metadata: meta,
+		Images:   make([]ScannedImage, 0, len(i)),
+	}
+	for u := range i {
+		r.Images = append(r.Images, ScannedImage{URI: u, UsedBy: i.UsedBy(u)})
+	}
+	sort.Slice(r.Images, func(a, b int) bool { return r.Images[a].URI < r.Images[b].URI })
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		return fmt.Errorf("failed to encode scan result: %w", err)
+	}
+	return nil
+}
+
+// loadScanResult loads the scanned file and adds the images.
+// The images in the format v1 are used by the file itself, because the resources using them are unknown.
+// It returns the scan result, and its metadata is empty for the format v1.
+func (i Images) loadScanResult(filename string) (*ScanResult, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return i.addScanResult(filename, b)
+}
+
+// addScanResult parses the scanned file content of the name (a file name or URL) and adds the images.
+func (i Images) addScanResult(name string, b []byte) (*ScanResult, error) {
+	r, err := parseScanResult(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode images in %s: %w", name, err)
+	}
+	if r.Version >= 2 {
+		log.Printf("[info] %s is scanned at %s (account:%s region:%s ecrm:%s)",
+			name, r.Metadata.ScannedAt.Format(time.RFC3339), r.Metadata.AccountID, r.Metadata.Region, r.Metadata.ECRMVersion)
+	}
+	for _, img := range r.Images {
+		if len(img.UsedBy) == 0 {
+			i.AddUsage(img.URI, UsedBy{ARN: name, Kind: UsedByScannedFile})
+			continue
+		}
+		for _, by := range img.UsedBy {
+			i.AddUsage(img.URI, by)
+		}
+	}
+	return r, nil
+}
+
+func parseScanResult(b []byte) (*ScanResult, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("[")) {
+		var uris []ImageURI
+		if err := json.Unmarshal(b, &uris); err != nil {
+			return nil, err
+		}
+		r := &ScanResult{Version: 1}
+		for _, u := range uris {
+			r.Images = append(r.Images, ScannedImage{URI: u})
+		}
+		return r, nil
+	}
+	var r ScanResult
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	if r.Version != ScanResultVersion {
+		return nil, fmt.Errorf("unsupported scanned file version %d", r.Version)
+	}
+	return &r, nil
+}
+
+// configHash returns a hash of the config to identify the config used by the scan.
+func configHash(c *Config) (string, error) {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(h[:]), nil
+}
+
+// scannedFile is a scanned file loaded by the scanner.
+type scannedFile struct {
+	name     string
+	version  int
+	metadata ScanMetadata
+}
+
+// checkScanAge reports the age of each scanned file and fails if any of them is older than maxAge.
+// Files of the format v1 are treated as stale because they have no timestamp.
+func (s *Scanner) checkScanAge(maxAge time.Duration, now time.Time) error {
+	var stale int
+	for _, f := range s.files {
+		scannedAt := f.metadata.ScannedAt
+		if f.version < 2 || scannedAt.IsZero() {
+			log.Printf("[error] %s has no timestamp (format v%d). rescan it by ecrm scan", f.name, f.version)
+			stale++
+			continue
+		}
+		age := now.Sub(scannedAt).Truncate(time.Second)
+		if age > maxAge {
+			log.Printf("[error] %s was scanned at %s (%s ago), older than max-scan-age %s", f.name, scannedAt.Format(time.RFC3339), age, maxAge)
+			stale++
+			continue
+		}
+		log.Printf("[info] %s was scanned at %s (%s ago)", f.name, scannedAt.Format(time.RFC3339), age)
+	}
+	if stale > 0 {
+		return fmt.Errorf("%d of %d scanned files are older than max-scan-age %s", stale, len(s.files), maxAge)
+	}
+	return nil
+}
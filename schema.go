@@ -0,0 +1,204 @@
+package ecrm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/invopop/jsonschema"
+)
+
+// SchemaID is the $id of the JSON Schema of the config file.
+const SchemaID = "https://github.com/fujiwara/ecrm/ecrm.schema.json"
+
+// durationUnits are the units of the duration accepted by expires.
+var durationUnits = []string{
+	"ns", "nsec", "nanosecond", "nanoseconds",
+	"us", "usec", "microsecond", "microseconds",
+	"ms", "msec", "millisecond", "milliseconds",
+	"s", "sec", "second", "seconds",
+	"m", "min", "minute", "minutes",
+	"h", "hour", "hours",
+	"d", "day", "days",
+	"w", "week", "weeks",
+}
+
+// durationPattern is a regular expression for durations like "30d" or "1w 3d".
+func durationPattern() string {
+	units := make([]string, len(durationUnits))
+	copy(units, durationUnits)
+	// longer units first to match "days" instead of "d"
+	sort.SliceStable(units, func(i, j int) bool { return len(units[i]) > len(units[j]) })
+	return fmt.Sprintf(`^( *[0-9]+(\.[0-9]+)? *(%s) *)+$`, strings.Join(units, "|"))
+}
+
+// Schema returns the JSON Schema of the config file.
+func Schema() *jsonschema.Schema {
+	r := &jsonschema.Reflector{
+		FieldNameTag:               "yaml",
+		RequiredFromJSONSchemaTags: true,
+	}
+	s := r.Reflect(&Config{})
+	s.ID = SchemaID
+	s.Title = "ecrm configuration"
+	return s
+}
+
+// WriteSchema writes the JSON Schema of the config file to w.
+func WriteSchema(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(Schema())
+}
+
+// PrintSchema prints the JSON Schema of the config file.
+func (app *App) PrintSchema(ctx context.Context, opt *Option) error {
+	w, err := opt.OutputWriter()
+	if err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
+	defer w.Close()
+	return WriteSchema(w)
+}
+
+// describe sets descriptions of the properties.
+func describe(s *jsonschema.Schema, descriptions map[string]string) {
+	for name, desc := range descriptions {
+		if p, ok := s.Properties.Get(name); ok {
+			p.Description = desc
+		}
+	}
+}
+
+// exclusiveNamesSchema returns a schema that allows at most one of name, name_pattern and name_regexp.
+func exclusiveNamesSchema() *jsonschema.Schema {
+	return &jsonschema.Schema{
+		AnyOf: []*jsonschema.Schema{
+			{Required: []string{"name", "name_pattern"}},
+			{Required: []string{"name", "name_regexp"}},
+			{Required: []string{"name_pattern", "name_regexp"}},
+		},
+	}
+}
+
+// nameDescriptions are descriptions of name, name_pattern and name_regexp. They are exclusive.
+var nameDescriptions = map[string]string{
+	"name":         "Exact name. Exclusive with name_pattern and name_regexp.",
+	"name_pattern": `Wildcard pattern of the name ("*" and "?"). Prefix with "!" to negate.`,
+	"name_regexp":  `Regular expression of the name. Prefix with "!" to negate.`,
+}
+
+func (Config) JSONSchemaExtend(s *jsonschema.Schema) {
+	describe(s, map[string]string{
+		"clusters":               "ECS clusters to scan images in use.",
+		"task_definitions":       "ECS task definitions to scan images in use.",
+		"lambda_functions":       "Lambda functions to scan images in use.",
+		"repositories":           "ECR repositories to manage.",
+		"repository_tags":        `Override repositories settings by the "ecrm:" tags of ECR repositories.`,
+		"repository_tags_opt_in": `Manage repositories that match no repositories entry by the "ecrm:expires" tag. Requires repository_tags.`,
+		"match_strategy":         "Strategy to find a repository config for a repository.",
+		"defaults":               "Default values for the entries that don't set them.",
+		"include":                "Config files (or glob patterns) to be merged. Paths are relative to the including file.",
+	})
+	if p, ok := s.Properties.Get("match_strategy"); ok {
+		p.Enum = []any{MatchStrategyFirst, MatchStrategyMostSpecific}
+		p.Default = MatchStrategyFirst
+	}
+}
+
+func (ClusterConfig) JSONSchemaExtend(s *jsonschema.Schema) {
+	describe(s, nameDescriptions)
+	s.AnyOf = []*jsonschema.Schema{
+		{Required: []string{"name"}},
+		{Required: []string{"name_pattern"}},
+		{Required: []string{"name_regexp"}},
+	}
+	// name and name_pattern are deprecated but accepted for backward compatibility.
+	s.Not = &jsonschema.Schema{
+		AnyOf: []*jsonschema.Schema{
+			{Required: []string{"name", "name_regexp"}},
+			{Required: []string{"name_pattern", "name_regexp"}},
+		},
+	}
+}
+
+func (TaskdefConfig) JSONSchemaExtend(s *jsonschema.Schema) {
+	describe(s, nameDescriptions)
+	describe(s, map[string]string{
+		"keep_count": "Number of the latest revisions to keep.",
+	})
+	if p, ok := s.Properties.Get("keep_count"); ok {
+		p.Default = DefaultKeepCount
+		p.Minimum = "0"
+	}
+	s.Not = exclusiveNamesSchema()
+}
+
+func (LambdaConfig) JSONSchemaExtend(s *jsonschema.Schema) {
+	describe(s, nameDescriptions)
+	describe(s, map[string]string{
+		"keep_count":  "Number of the latest versions to keep.",
+		"keep_aliase": "Obsoleted. All aliased versions are always kept.",
+	})
+	if p, ok := s.Properties.Get("keep_count"); ok {
+		p.Default = DefaultKeepCount
+		p.Minimum = "0"
+	}
+	if p, ok := s.Properties.Get("keep_aliase"); ok {
+		p.Deprecated = true
+	}
+	s.Not = exclusiveNamesSchema()
+}
+
+func (RepositoryConfig) JSONSchemaExtend(s *jsonschema.Schema) {
+	describe(s, nameDescriptions)
+	describe(s, map[string]string{
+		"expires":           `Images pushed before this duration are expired. e.g. "30d", "2w"`,
+		"keep_count":        "Number of the latest tagged images to keep even if expired.",
+		"keep_tag_patterns": `Wildcard patterns of the tags to keep. Prefix with "!" to negate.`,
+		"tag_regexp":        `Regular expression of the tags to keep. Prefix with "!" to negate.`,
+		"semver":            "Retention rule for tags that are semantic versions.",
+	})
+	if p, ok := s.Properties.Get("expires"); ok {
+		p.Pattern = durationPattern()
+	}
+	if p, ok := s.Properties.Get("keep_count"); ok {
+		p.Minimum = "0"
+	}
+	if p, ok := s.Properties.Get("keep_tag_patterns"); ok {
+		p.Default = DefaultKeepTagPatterns
+	}
+	// expires is required, but it may be given by the defaults in the including file.
+	s.Not = exclusiveNamesSchema()
+}
+
+func (RepositoryDefaults) JSONSchemaExtend(s *jsonschema.Schema) {
+	if p, ok := s.Properties.Get("expires"); ok {
+		p.Pattern = durationPattern()
+	}
+	if p, ok := s.Properties.Get("keep_count"); ok {
+		p.Minimum = "0"
+	}
+}
+
+func (SemverConfig) JSONSchemaExtend(s *jsonschema.Schema) {
+	describe(s, map[string]string{
+		"keep_major":         "Number of the highest major versions to keep. 0 means all.",
+		"keep_minor":         "Number of the highest minor versions to keep for each major version. 0 means all.",
+		"keep_patch":         "Number of the highest patch versions to keep for each minor version. 0 means all.",
+		"include_prerelease": "Treat prerelease versions as semantic versions.",
+	})
+	for _, name := range []string{"keep_major", "keep_minor", "keep_patch"} {
+		if p, ok := s.Properties.Get(name); ok {
+			p.Minimum = "0"
+		}
+	}
+	s.AnyOf = []*jsonschema.Schema{
+		{Required: []string{"keep_major"}},
+		{Required: []string{"keep_minor"}},
+		{Required: []string{"keep_patch"}},
+	}
+}
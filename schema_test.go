@@ -0,0 +1,43 @@
+package ecrm_test
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+	"github.com/k1LoW/duration"
+)
+
+func TestSchemaInSync(t *testing.T) {
+	b, err := os.ReadFile("ecrm.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := ecrm.WriteSchema(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(b), buf.String()); diff != "" {
+		t.Errorf("ecrm.schema.json is out of date. run `go run ./cmd/ecrm schema -o ecrm.schema.json` (-want +got):\n%s", diff)
+	}
+}
+
+func TestSchemaDurationPattern(t *testing.T) {
+	re := regexp.MustCompile(ecrm.DurationPattern())
+	for _, s := range []string{"30d", "1w", "2 weeks", "1.5h", "1d12h", "90days", "1w 3d"} {
+		if _, err := duration.Parse(s); err != nil {
+			t.Errorf("%s is invalid duration: %s", s, err)
+		}
+		if !re.MatchString(s) {
+			t.Errorf("%s must match the duration pattern", s)
+		}
+	}
+	for _, s := range []string{"", "30", "d", "30x", "thirty days", "-1d"} {
+		if re.MatchString(s) {
+			t.Errorf("%s must not match the duration pattern", s)
+		}
+	}
+}
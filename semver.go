@@ -0,0 +1,99 @@
+package ecrm
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// SemverConfig is a retention rule for tags that are semantic versions.
+//
+// Tags are parsed as semantic versions (with an optional "v" prefix).
+// The highest KeepMajor major versions are kept, and for each of them the highest KeepMinor minor versions,
+// and for each of them the highest KeepPatch patch versions. Zero means "all".
+type SemverConfig struct {
+	KeepMajor         int  `yaml:"keep_major,omitempty"`
+	KeepMinor         int  `yaml:"keep_minor,omitempty"`
+	KeepPatch         int  `yaml:"keep_patch,omitempty"`
+	IncludePrerelease bool `yaml:"include_prerelease,omitempty"`
+}
+
+func (s *SemverConfig) Validate() error {
+	if s.KeepMajor < 0 || s.KeepMinor < 0 || s.KeepPatch < 0 {
+		return errors.New("semver keep_major, keep_minor and keep_patch must not be negative")
+	}
+	if s.KeepMajor == 0 && s.KeepMinor == 0 && s.KeepPatch == 0 {
+		return errors.New("semver requires at least one of keep_major, keep_minor or keep_patch")
+	}
+	return nil
+}
+
+func parseSemverTag(tag string) (*semver.Version, bool) {
+	v, err := semver.StrictNewVersion(strings.TrimPrefix(tag, "v"))
+	if err != nil {
+		return nil, false
+	}
+	return v, true
+}
+
+// keepTags returns a set of tags that are kept by the semver rule.
+func (s *SemverConfig) keepTags(tags []string) set {
+	keep := newSet()
+	if s == nil {
+		return keep
+	}
+
+	// group tags by version. v1.2.3 and 1.2.3 are the same version.
+	tagsByVersion := make(map[string][]string)
+	versions := make([]*semver.Version, 0)
+	for _, tag := range tags {
+		v, ok := parseSemverTag(tag)
+		if !ok {
+			continue
+		}
+		if v.Prerelease() != "" && !s.IncludePrerelease {
+			continue
+		}
+		key := v.String()
+		if _, found := tagsByVersion[key]; !found {
+			versions = append(versions, v)
+		}
+		tagsByVersion[key] = append(tagsByVersion[key], tag)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].GreaterThan(versions[j])
+	})
+
+	var majors, minors, patches int
+	var lastMajor, lastMinor *semver.Version
+	for _, v := range versions {
+		if lastMajor == nil || lastMajor.Major() != v.Major() {
+			majors++
+			minors, patches = 0, 0
+			lastMajor, lastMinor = v, nil
+		}
+		if lastMinor == nil || lastMinor.Minor() != v.Minor() {
+			minors++
+			patches = 0
+			lastMinor = v
+		}
+		patches++
+		if s.KeepMajor > 0 && majors > s.KeepMajor {
+			break // versions are sorted, so all following majors are out of range
+		}
+		if s.KeepMinor > 0 && minors > s.KeepMinor {
+			continue
+		}
+		if s.KeepPatch > 0 && patches > s.KeepPatch {
+			continue
+		}
+		for _, tag := range tagsByVersion[v.String()] {
+			log.Printf("[debug] tag %s is kept by semver rule", tag)
+			keep.add(tag)
+		}
+	}
+	return keep
+}
@@ -0,0 +1,61 @@
+package ecrm_test
+
+import (
+	"testing"
+
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+var semverTags = []string{
+	"latest",
+	"fe668fb9",
+	"v1.0.0", "v1.0.1", "v1.1.0", "v1.1.1", "v1.2.0",
+	"v2.0.0", "v2.0.1", "2.0.1", "v2.1.0", "v2.2.0-rc.1",
+	"v3.0.0-beta.1",
+}
+
+var semverKeepTagsTests = []struct {
+	name   string
+	config ecrm.SemverConfig
+	expect []string
+}{
+	{
+		name:   "latest major",
+		config: ecrm.SemverConfig{KeepMajor: 1},
+		expect: []string{"v2.0.0", "v2.0.1", "2.0.1", "v2.1.0"},
+	},
+	{
+		name:   "latest 2 minors and latest patch of each",
+		config: ecrm.SemverConfig{KeepMajor: 1, KeepMinor: 2, KeepPatch: 1},
+		expect: []string{"v2.0.1", "2.0.1", "v2.1.0"},
+	},
+	{
+		name:   "latest patch of each minor",
+		config: ecrm.SemverConfig{KeepPatch: 1},
+		expect: []string{"v1.0.1", "v1.1.1", "v1.2.0", "v2.0.1", "2.0.1", "v2.1.0"},
+	},
+	{
+		name:   "include prerelease",
+		config: ecrm.SemverConfig{KeepMajor: 1, KeepMinor: 1, IncludePrerelease: true},
+		expect: []string{"v3.0.0-beta.1"},
+	},
+	{
+		name:   "include prerelease latest 2 minors",
+		config: ecrm.SemverConfig{KeepMajor: 2, KeepMinor: 1, IncludePrerelease: true},
+		expect: []string{"v3.0.0-beta.1", "v2.2.0-rc.1"},
+	},
+}
+
+func TestSemverKeepTags(t *testing.T) {
+	for _, tt := range semverKeepTagsTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ecrm.SemverKeepTags(&tt.config, semverTags)
+			// the kept tags are a set
+			if diff := cmp.Diff(tt.expect, got, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+				t.Errorf("unexpected kept tags: %s", diff)
+			}
+		})
+	}
+}
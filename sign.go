@@ -0,0 +1,326 @@
+package ecrm
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmsTypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// SignatureSuffix is the suffix of the detached signature file of a scanned file.
+const SignatureSuffix = ".sig"
+
+const (
+	signatureAlgorithmEd25519 = "ed25519"
+	signatureAlgorithmKMS     = "kms"
+)
+
+// DefaultKMSSigningAlgorithm is the default signing algorithm of KMS.
+var DefaultKMSSigningAlgorithm = string(kmsTypes.SigningAlgorithmSpecEcdsaSha256)
+
+// Signature is a detached signature of a scanned file.
+// The signature is made for the SHA-256 digest of the file.
+type Signature struct {
+	Algorithm        string `json:"algorithm"`
+	SigningAlgorithm string `json:"signing_algorithm,omitempty"` // KMS only
+	KeyID            string `json:"key_id,omitempty"`
+	SHA256           string `json:"sha256"`
+	Size             int    `json:"size"`
+	Signature        []byte `json:"signature"`
+}
+
+type signer interface {
+	sign(ctx context.Context, digest []byte) (*Signature, error)
+}
+
+type verifier interface {
+	verify(ctx context.Context, digest []byte, sig *Signature) error
+}
+
+// ed25519Key signs and verifies by the ed25519 key.
+type ed25519Key struct {
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+// keyID returns the fingerprint of the public key.
+func (k *ed25519Key) keyID() string {
+	h := sha256.Sum256(k.public)
+	return "sha256:" + hex.EncodeToString(h[:8])
+}
+
+func (k *ed25519Key) sign(_ context.Context, digest []byte) (*Signature, error) {
+	return &Signature{
+		Algorithm: signatureAlgorithmEd25519,
+		KeyID:     k.keyID(),
+		Signature: ed25519.Sign(k.private, digest),
+	}, nil
+}
+
+func (k *ed25519Key) verify(_ context.Context, digest []byte, sig *Signature) error {
+	if sig.Algorithm != signatureAlgorithmEd25519 {
+		return fmt.Errorf("unexpected signature algorithm %s, expected %s", sig.Algorithm, signatureAlgorithmEd25519)
+	}
+	if !ed25519.Verify(k.public, digest, sig.Signature) {
+		return fmt.Errorf("invalid signature by the key %s", k.keyID())
+	}
+	return nil
+}
+
+func readPEM(path string, blockType string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s is not a PEM file of %s", path, blockType)
+	}
+	return block.Bytes, nil
+}
+
+// loadEd25519PrivateKey loads the ed25519 private key in PKCS #8 PEM. e.g. openssl genpkey -algorithm ed25519
+func loadEd25519PrivateKey(path string) (*ed25519Key, error) {
+	der, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 private key", path)
+	}
+	return &ed25519Key{private: private, public: private.Public().(ed25519.PublicKey)}, nil
+}
+
+// loadEd25519PublicKey loads the ed25519 public key in PKIX PEM. e.g. openssl pkey -pubout
+func loadEd25519PublicKey(path string) (*ed25519Key, error) {
+	der, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 public key", path)
+	}
+	return &ed25519Key{public: public}, nil
+}
+
+// kmsKey signs and verifies by the asymmetric KMS key.
+type kmsKey struct {
+	client    *kms.Client
+	keyID     string
+	algorithm kmsTypes.SigningAlgorithmSpec
+}
+
+func newKMSKey(cfg aws.Config, keyID, algorithm string) (*kmsKey, error) {
+	if algorithm == "" {
+		algorithm = DefaultKMSSigningAlgorithm
+	}
+	// the digest is SHA-256
+	if !strings.HasSuffix(algorithm, "_SHA_256") {
+		return nil, fmt.Errorf("unsupported KMS signing algorithm %s. must be one of *_SHA_256", algorithm)
+	}
+	return &kmsKey{
+		client:    kms.NewFromConfig(cfg),
+		keyID:     keyID,
+		algorithm: kmsTypes.SigningAlgorithmSpec(algorithm),
+	}, nil
+}
+
+func (k *kmsKey) sign(ctx context.Context, digest []byte) (*Signature, error) {
+	out, err := k.client.Sign(ctx, &kms.SignInput{
+		KeyId:            aws.String(k.keyID),
+		Message:          digest,
+		MessageType:      kmsTypes.MessageTypeDigest,
+		SigningAlgorithm: k.algorithm,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign by KMS key %s: %w", k.keyID, err)
+	}
+	return &Signature{
+		Algorithm:        signatureAlgorithmKMS,
+		SigningAlgorithm: string(out.SigningAlgorithm),
+		KeyID:            aws.ToString(out.KeyId),
+		Signature:        out.Signature,
+	}, nil
+}
+
+func (k *kmsKey) verify(ctx context.Context, digest []byte, sig *Signature) error {
+	if sig.Algorithm != signatureAlgorithmKMS {
+		return fmt.Errorf("unexpected signature algorithm %s, expected %s", sig.Algorithm, signatureAlgorithmKMS)
+	}
+	// the key is specified by the verifier, not by the signature file
+	out, err := k.client.Verify(ctx, &kms.VerifyInput{
+		KeyId:            aws.String(k.keyID),
+		Message:          digest,
+		MessageType:      kmsTypes.MessageTypeDigest,
+		Signature:        sig.Signature,
+		SigningAlgorithm: k.algorithm,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to verify by KMS key %s: %w", k.keyID, err)
+	}
+	if !out.SignatureValid {
+		return fmt.Errorf("invalid signature by KMS key %s", k.keyID)
+	}
+	return nil
+}
+
+// signContent signs the content and returns the detached signature file.
+func signContent(ctx context.Context, s signer, content []byte) ([]byte, error) {
+	digest := sha256.Sum256(content)
+	sig, err := s.sign(ctx, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	sig.SHA256 = hex.EncodeToString(digest[:])
+	sig.Size = len(content)
+	b, err := json.MarshalIndent(sig, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// verifyContent verifies the checksum and the signature of the content.
+func verifyContent(ctx context.Context, v verifier, name string, content, sigFile []byte) error {
+	var sig Signature
+	if err := json.Unmarshal(sigFile, &sig); err != nil {
+		return fmt.Errorf("failed to parse the signature of %s: %w", name, err)
+	}
+	digest := sha256.Sum256(content)
+	if len(content) != sig.Size || hex.EncodeToString(digest[:]) != sig.SHA256 {
+		return fmt.Errorf("checksum mismatch of %s: %d bytes sha256:%x, signed %d bytes sha256:%s. the file may be truncated or tampered",
+			name, len(content), digest, sig.Size, sig.SHA256)
+	}
+	if err := v.verify(ctx, digest[:], &sig); err != nil {
+		return fmt.Errorf("signature verification of %s failed: %w", name, err)
+	}
+	log.Printf("[info] verified the signature of %s (%s %s)", name, sig.Algorithm, sig.KeyID)
+	return nil
+}
+
+// signingWriter writes the content to the output and the detached signature to the output + SignatureSuffix on Close.
+type signingWriter struct {
+	buf    strings.Builder
+	name   string
+	signer signer
+	open   func(name string) (io.WriteCloser, error)
+}
+
+func (w *signingWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *signingWriter) Close() error {
+	content := []byte(w.buf.String())
+	sig, err := signContent(context.Background(), w.signer, content)
+	if err != nil {
+		return err
+	}
+	// the signature is written first, so a failure leaves the existing output and signature unchanged
+	sigName := w.name + SignatureSuffix
+	if err := w.write(sigName, sig); err != nil {
+		return fmt.Errorf("failed to write the signature %s: %w. %s is not written", sigName, err, w.name)
+	}
+	if err := w.write(w.name, content); err != nil {
+		return fmt.Errorf("failed to write %s: %w. the signature %s does not match the existing %s, write it again", w.name, err, sigName, w.name)
+	}
+	log.Println("[info] signed", w.name)
+	return nil
+}
+
+func (w *signingWriter) write(name string, content []byte) error {
+	out, err := w.open(name)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(content); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// SignatureOption is an option for signing and verifying scanned files.
+type SignatureOption struct {
+	SignKey             string // ed25519 private key file (PKCS #8 PEM)
+	SignKMSKeyID        string
+	VerifyKey           string // ed25519 public key file (PKIX PEM)
+	VerifyKMSKeyID      string
+	KMSSigningAlgorithm string // the default is ECDSA_SHA_256
+}
+
+// signer returns the signer of the option. It returns nil if signing is not enabled.
+func (opt *SignatureOption) signer(cfg *aws.Config) (signer, error) {
+	switch {
+	case opt.SignKey != "" && opt.SignKMSKeyID != "":
+		return nil, errors.New("sign-key and sign-kms-key-id are exclusive")
+	case opt.SignKey != "":
+		return loadEd25519PrivateKey(opt.SignKey)
+	case opt.SignKMSKeyID != "":
+		if cfg == nil {
+			return nil, errors.New("AWS config is required to sign by KMS")
+		}
+		return newKMSKey(*cfg, opt.SignKMSKeyID, opt.KMSSigningAlgorithm)
+	}
+	return nil, nil
+}
+
+// verifier returns the verifier of the option. It returns nil if verification is not enabled.
+func (opt *SignatureOption) verifier(cfg *aws.Config) (verifier, error) {
+	switch {
+	case opt.VerifyKey != "" && opt.VerifyKMSKeyID != "":
+		return nil, errors.New("verify-key and verify-kms-key-id are exclusive")
+	case opt.VerifyKey != "":
+		return loadEd25519PublicKey(opt.VerifyKey)
+	case opt.VerifyKMSKeyID != "":
+		if cfg == nil {
+			return nil, errors.New("AWS config is required to verify by KMS")
+		}
+		return newKMSKey(*cfg, opt.VerifyKMSKeyID, opt.KMSSigningAlgorithm)
+	}
+	return nil, nil
+}
+
+// signedOutputWriter returns a writer of the output file that also writes the detached signature if signing is enabled.
+func signedOutputWriter(name string, cfg *aws.Config, kmsKeyID string, sig *SignatureOption) (io.WriteCloser, error) {
+	s, err := sig.signer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return outputWriterS3(name, cfg, kmsKeyID)
+	}
+	if name == "" || name == "-" {
+		return nil, errors.New("signing requires the output file (or s3://bucket/key), not STDOUT")
+	}
+	return &signingWriter{
+		name:   name,
+		signer: s,
+		open: func(name string) (io.WriteCloser, error) {
+			return outputWriterS3(name, cfg, kmsKeyID)
+		},
+	}, nil
+}
@@ -0,0 +1,465 @@
+package ecrm_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fujiwara/ecrm"
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeEd25519Keys(t *testing.T, dir string) (privateKeyFile, publicKeyFile string) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKeyFile = filepath.Join(dir, "ecrm.key")
+	publicKeyFile = filepath.Join(dir, "ecrm.pub")
+	if err := os.WriteFile(privateKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(publicKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return privateKeyFile, publicKeyFile
+}
+
+func newSignedScanner() *ecrm.Scanner {
+	scanner := ecrm.NewScanner(*testRemoteConfigOption("http://127.0.0.1").AWSConfig)
+	scanner.AddUsage(ecrm.ImageURI("012345678901.dkr.ecr.us-east-1.amazonaws.com/app:latest"), ecrm.UsedBy{
+		ARN:  "arn:aws:lambda:us-east-1:012345678901:function:app",
+		Kind: ecrm.UsedByLambdaFunction,
+	})
+	return scanner
+}
+
+func TestSignScannedFileEd25519(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	privateKeyFile, publicKeyFile := writeEd25519Keys(t, dir)
+	_, otherPublicKeyFile := writeEd25519Keys(t, t.TempDir())
+	output := filepath.Join(dir, "scanned.json")
+
+	err := ecrm.ShowScanResult(newSignedScanner(), &ecrm.Option{
+		OutputFile:      output,
+		SignatureOption: ecrm.SignatureOption{SignKey: privateKeyFile},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	load := func(t *testing.T, publicKeyFile string) error {
+		t.Helper()
+		scanner := ecrm.NewScanner(*testRemoteConfigOption("http://127.0.0.1").AWSConfig)
+		if err := ecrm.SetScannerVerifier(scanner, &ecrm.SignatureOption{VerifyKey: publicKeyFile}, nil); err != nil {
+			t.Fatal(err)
+		}
+		return scanner.LoadFiles(ctx, []string{output})
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		if err := load(t, publicKeyFile); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("other key", func(t *testing.T) {
+		if err := load(t, otherPublicKeyFile); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+			t.Errorf("expected invalid signature, got %v", err)
+		}
+	})
+	t.Run("tampered", func(t *testing.T) {
+		tampered := strings.Replace(string(content), "app:latest", "app:v1.0.0", 1)
+		if err := os.WriteFile(output, []byte(tampered), 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer os.WriteFile(output, content, 0644)
+		if err := load(t, publicKeyFile); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Errorf("expected checksum mismatch, got %v", err)
+		}
+	})
+	t.Run("truncated", func(t *testing.T) {
+		if err := os.WriteFile(output, content[:len(content)/2], 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer os.WriteFile(output, content, 0644)
+		if err := load(t, publicKeyFile); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Errorf("expected checksum mismatch, got %v", err)
+		}
+	})
+	t.Run("missing signature", func(t *testing.T) {
+		unsigned := filepath.Join(dir, "unsigned.json")
+		if err := os.WriteFile(unsigned, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		scanner := ecrm.NewScanner(*testRemoteConfigOption("http://127.0.0.1").AWSConfig)
+		if err := ecrm.SetScannerVerifier(scanner, &ecrm.SignatureOption{VerifyKey: publicKeyFile}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := scanner.LoadFiles(ctx, []string{unsigned}); err == nil {
+			t.Error("expected error for the missing signature")
+		}
+	})
+}
+
+func TestSignScannedFileToStdout(t *testing.T) {
+	privateKeyFile, _ := writeEd25519Keys(t, t.TempDir())
+	err := ecrm.ShowScanResult(newSignedScanner(), &ecrm.Option{
+		OutputFile:      "-",
+		SignatureOption: ecrm.SignatureOption{SignKey: privateKeyFile},
+	})
+	if err == nil {
+		t.Error("expected error for signing STDOUT")
+	}
+}
+
+// kmsStandIn is a local stand-in of KMS supporting Sign and Verify with MessageType DIGEST by an in-process ECDSA key.
+type kmsStandIn struct {
+	key *ecdsa.PrivateKey
+}
+
+type kmsRequest struct {
+	KeyId            string
+	Message          []byte
+	MessageType      string
+	Signature        []byte
+	SigningAlgorithm string
+}
+
+func (s *kmsStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req kmsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.MessageType != "DIGEST" || req.SigningAlgorithm != "ECDSA_SHA_256" {
+		http.Error(w, "unsupported", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	switch r.Header.Get("X-Amz-Target") {
+	case "TrentService.Sign":
+		sig, err := ecdsa.SignASN1(rand.Reader, s.key, req.Message)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"KeyId":            "arn:aws:kms:us-east-1:012345678901:key/" + req.KeyId,
+			"Signature":        sig,
+			"SigningAlgorithm": req.SigningAlgorithm,
+		})
+	case "TrentService.Verify":
+		if !ecdsa.VerifyASN1(&s.key.PublicKey, req.Message, req.Signature) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"__type":  "KMSInvalidSignatureException",
+				"message": "signature is invalid",
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"KeyId":            req.KeyId,
+			"SignatureValid":   true,
+			"SigningAlgorithm": req.SigningAlgorithm,
+		})
+	default:
+		http.Error(w, "unsupported", http.StatusBadRequest)
+	}
+}
+
+// awsStandIn dispatches KMS requests (with X-Amz-Target) to kms and others to s3.
+type awsStandIn struct {
+	kms *kmsStandIn
+	s3  *s3StandIn
+}
+
+func (s *awsStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.Header.Get("X-Amz-Target"), "TrentService.") {
+		s.kms.ServeHTTP(w, r)
+		return
+	}
+	s.s3.ServeHTTP(w, r)
+}
+
+func TestSignScannedFileKMS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	standIn := &awsStandIn{
+		kms: &kmsStandIn{key: key},
+		s3:  &s3StandIn{objects: map[string][]byte{}, headers: map[string]http.Header{}},
+	}
+	ts := httptest.NewServer(standIn)
+	defer ts.Close()
+	ctx := context.Background()
+	cfg := testRemoteConfigOption(ts.URL).AWSConfig
+
+	// the scanned file and the signature are in S3, signed by KMS
+	output := "s3://scans/ecrm/scanned.json"
+	err = ecrm.ShowScanResult(newSignedScanner(), &ecrm.Option{
+		OutputFile:      output,
+		SignatureOption: ecrm.SignatureOption{SignKMSKeyID: "alias/ecrm-sign"},
+		AWSConfig:       cfg,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := standIn.s3.objects["/scans/ecrm/scanned.json.sig"]; !ok {
+		t.Error("signature is not uploaded")
+	}
+
+	for _, tc := range []struct {
+		name    string
+		files   []string
+		wantErr bool
+	}{
+		{name: "object", files: []string{output}},
+		{name: "prefix", files: []string{"s3://scans/ecrm/"}}, // the signature object is not loaded as a scanned file
+		{name: "missing signature", files: []string{"s3://scans/ecrm/missing.json"}, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			scanner := ecrm.NewScanner(*cfg)
+			if err := ecrm.SetScannerVerifier(scanner, &ecrm.SignatureOption{VerifyKMSKeyID: "alias/ecrm-sign"}, cfg); err != nil {
+				t.Fatal(err)
+			}
+			err := scanner.LoadFiles(ctx, tc.files)
+			if tc.wantErr {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(scanner.Images()) != 1 {
+				t.Errorf("unexpected images: %v", scanner.Images())
+			}
+		})
+	}
+
+	t.Run("other key", func(t *testing.T) {
+		other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts := httptest.NewServer(&awsStandIn{kms: &kmsStandIn{key: other}, s3: standIn.s3})
+		defer ts.Close()
+		cfg := testRemoteConfigOption(ts.URL).AWSConfig
+		scanner := ecrm.NewScanner(*cfg)
+		if err := ecrm.SetScannerVerifier(scanner, &ecrm.SignatureOption{VerifyKMSKeyID: "alias/ecrm-sign"}, cfg); err != nil {
+			t.Fatal(err)
+		}
+		if err := scanner.LoadFiles(ctx, []string{output}); err == nil {
+			t.Error("expected error for the signature by the other key")
+		}
+	})
+}
+
+func TestSignatureOptionExclusive(t *testing.T) {
+	scanner := ecrm.NewScanner(*testRemoteConfigOption("http://127.0.0.1").AWSConfig)
+	err := ecrm.SetScannerVerifier(scanner, &ecrm.SignatureOption{VerifyKey: "ecrm.pub", VerifyKMSKeyID: "alias/ecrm"}, nil)
+	if err == nil {
+		t.Error("expected error for exclusive options")
+	}
+	err = ecrm.SetScannerVerifier(scanner, &ecrm.SignatureOption{VerifyKMSKeyID: "alias/ecrm", KMSSigningAlgorithm: "RSASSA_PSS_SHA_512"}, testRemoteConfigOption("http://127.0.0.1").AWSConfig)
+	if err == nil {
+		t.Error("expected error for the unsupported algorithm")
+	}
+}
+
+func TestMergeSignedScannedFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	privateKeyFile, publicKeyFile := writeEd25519Keys(t, dir)
+	input := filepath.Join(dir, "scanned.json")
+	err := ecrm.ShowScanResult(newSignedScanner(), &ecrm.Option{
+		OutputFile:      input,
+		SignatureOption: ecrm.SignatureOption{SignKey: privateKeyFile},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := ecrm.NewWithConfig(testAWSConfig())
+	verify := ecrm.SignatureOption{VerifyKey: publicKeyFile}
+
+	merged := filepath.Join(dir, "merged.json")
+	err = app.MergeScannedFiles(ctx, &ecrm.MergeOption{
+		Files:           []string{input},
+		OutputFile:      merged,
+		SignatureOption: ecrm.SignatureOption{SignKey: privateKeyFile, VerifyKey: publicKeyFile},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the merged output is signed and verified by the same key
+	scanner := ecrm.NewScanner(testAWSConfig())
+	if err := ecrm.SetScannerVerifier(scanner, &verify, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := scanner.LoadFiles(ctx, []string{merged}); err != nil {
+		t.Errorf("failed to verify the merged file: %v", err)
+	}
+	if err := app.DiffScannedFiles(ctx, &ecrm.DiffOption{
+		Old:             input,
+		New:             merged,
+		OutputFile:      filepath.Join(dir, "diff.txt"),
+		Format:          "text",
+		SignatureOption: verify,
+	}); err != nil {
+		t.Errorf("failed to diff the signed files: %v", err)
+	}
+
+	content, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := strings.Replace(string(content), "app:latest", "app:v1.0.0", 1)
+	if err := os.WriteFile(input, []byte(tampered), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = app.MergeScannedFiles(ctx, &ecrm.MergeOption{
+		Files:           []string{input},
+		OutputFile:      filepath.Join(dir, "tampered.json"),
+		SignatureOption: verify,
+	})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected checksum mismatch, got %v", err)
+	}
+	err = app.DiffScannedFiles(ctx, &ecrm.DiffOption{
+		Old:             input,
+		New:             merged,
+		OutputFile:      filepath.Join(dir, "diff.txt"),
+		Format:          "text",
+		SignatureOption: verify,
+	})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected checksum mismatch, got %v", err)
+	}
+}
+
+func TestSignScannedFileWriteFailure(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	privateKeyFile, publicKeyFile := writeEd25519Keys(t, dir)
+	output := filepath.Join(dir, "scanned.json")
+	opt := &ecrm.Option{
+		OutputFile:      output,
+		SignatureOption: ecrm.SignatureOption{SignKey: privateKeyFile},
+	}
+	if err := ecrm.ShowScanResult(newSignedScanner(), opt); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failure of the signature leaves the signed output unchanged
+	if err := os.Rename(output+ecrm.SignatureSuffix, output+".bak"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(output+ecrm.SignatureSuffix, 0755); err != nil {
+		t.Fatal(err)
+	}
+	scanner := newSignedScanner()
+	scanner.AddUsage(ecrm.ImageURI("012345678901.dkr.ecr.us-east-1.amazonaws.com/app:v1"), ecrm.UsedBy{
+		ARN:  "arn:aws:lambda:us-east-1:012345678901:function:app",
+		Kind: ecrm.UsedByLambdaFunction,
+	})
+	err := ecrm.ShowScanResult(scanner, opt)
+	if err == nil || !strings.Contains(err.Error(), "failed to write the signature") {
+		t.Errorf("expected the signature failure, got %v", err)
+	}
+	if err := os.Remove(output + ecrm.SignatureSuffix); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(output+".bak", output+ecrm.SignatureSuffix); err != nil {
+		t.Fatal(err)
+	}
+	verifier := ecrm.NewScanner(testAWSConfig())
+	if err := ecrm.SetScannerVerifier(verifier, &ecrm.SignatureOption{VerifyKey: publicKeyFile}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.LoadFiles(ctx, []string{output}); err != nil {
+		t.Errorf("the existing output must be kept verified: %v", err)
+	}
+	if len(verifier.Images()) != 1 {
+		t.Errorf("the existing output must not be overwritten: %v", verifier.Images())
+	}
+}
+
+func TestUnverifiedSignature(t *testing.T) {
+	standIn := &s3StandIn{objects: map[string][]byte{}, headers: map[string]http.Header{}}
+	ts := httptest.NewServer(standIn)
+	defer ts.Close()
+	ctx := context.Background()
+	cfg := testRemoteConfigOption(ts.URL).AWSConfig
+	dir := t.TempDir()
+	privateKeyFile, _ := writeEd25519Keys(t, dir)
+
+	signed := filepath.Join(dir, "signed.json")
+	unsigned := filepath.Join(dir, "unsigned.json")
+	for _, output := range []string{"s3://scans/ecrm/signed.json", signed} {
+		err := ecrm.ShowScanResult(newSignedScanner(), &ecrm.Option{
+			OutputFile:      output,
+			AWSConfig:       cfg,
+			SignatureOption: ecrm.SignatureOption{SignKey: privateKeyFile},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, output := range []string{"s3://scans/ecrm/unsigned.json", unsigned} {
+		if err := ecrm.ShowScanResult(newSignedScanner(), &ecrm.Option{OutputFile: output, AWSConfig: cfg}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var logs bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logs)
+	standIn.gets = nil
+	scanner := ecrm.NewScanner(*cfg)
+	if err := scanner.LoadFiles(ctx, []string{"s3://scans/ecrm/", signed, unsigned}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the signatures are not read without the verifier
+	if diff := cmp.Diff([]string{"/scans/ecrm/signed.json", "/scans/ecrm/unsigned.json"}, standIn.gets); diff != "" {
+		t.Errorf("unexpected GetObject requests (-want +got):\n%s", diff)
+	}
+	var warned []string
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if _, after, ok := strings.Cut(line, "[warn] "); ok {
+			warned = append(warned, strings.Fields(after)[0])
+		}
+	}
+	if diff := cmp.Diff([]string{"s3://scans/ecrm/signed.json", signed}, warned); diff != "" {
+		t.Errorf("unexpected warnings of the unverified signatures (-want +got):\n%s", diff)
+	}
+}
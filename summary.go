@@ -20,6 +20,7 @@ const (
 	SummaryTypeImage      = "Image"
 	SummaryTypeImageIndex = "Image index"
 	SummaryTypeSociIndex  = "Soci index"
+	SummaryTypeReferrer   = "Referrer"
 )
 
 type RepoSummary []*Summary
@@ -29,6 +30,7 @@ func NewRepoSummary(repo RepositoryName) RepoSummary {
 		{Repo: repo, Type: SummaryTypeImage},
 		{Repo: repo, Type: SummaryTypeImageIndex},
 		{Repo: repo, Type: SummaryTypeSociIndex},
+		{Repo: repo, Type: SummaryTypeReferrer},
 	}
 }
 
@@ -49,32 +51,90 @@ func (s RepoSummary) toIndex(img ecrTypes.ImageDetail) int {
 }
 
 func (s RepoSummary) Add(img ecrTypes.ImageDetail) {
-	index := s.toIndex(img)
+	s.add(s.toIndex(img), img)
+}
+
+func (s RepoSummary) Expire(img ecrTypes.ImageDetail) {
+	s.expire(s.toIndex(img), img)
+}
+
+// AddReferrer adds the referrer artifact (signature, SBOM, attestation, etc.) to the summary.
+func (s RepoSummary) AddReferrer(img ecrTypes.ImageDetail) {
+	s.add(s.indexOf(SummaryTypeReferrer), img)
+}
+
+// ExpireReferrer expires the referrer artifact in the summary.
+func (s RepoSummary) ExpireReferrer(img ecrTypes.ImageDetail) {
+	s.expire(s.indexOf(SummaryTypeReferrer), img)
+}
+
+// indexOf returns the index of the summary of the type. It returns -1 if not found.
+func (s RepoSummary) indexOf(typ string) int {
+	for i, sum := range s {
+		if sum.Type == typ {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s RepoSummary) add(index int, img ecrTypes.ImageDetail) {
 	if index >= 0 {
 		s[index].TotalImages++
 		s[index].TotalImageSize += aws.ToInt64(img.ImageSizeInBytes)
 	}
 }
 
-func (s RepoSummary) Expire(img ecrTypes.ImageDetail) {
-	index := s.toIndex(img)
+func (s RepoSummary) expire(index int, img ecrTypes.ImageDetail) {
 	if index >= 0 {
 		s[index].ExpiredImages++
 		s[index].ExpiredImageSize += aws.ToInt64(img.ImageSizeInBytes)
 	}
 }
 
+// SetConfig sets the effective repository config to the summaries.
+func (s RepoSummary) SetConfig(rc *RepositoryConfig, overriddenByTags []string) {
+	ec := &EffectiveConfig{
+		Expires:          rc.Expires,
+		KeepCount:        rc.KeepCount,
+		KeepTagPatterns:  rc.KeepTagPatterns,
+		OverriddenByTags: overriddenByTags,
+	}
+	for _, sum := range s {
+		sum.Config = ec
+	}
+}
+
 type Summary struct {
-	Repo             RepositoryName `json:"repository"`
-	Type             string         `json:"type"`
-	ExpiredImages    int64          `json:"expired_images"`
-	TotalImages      int64          `json:"total_images"`
-	ExpiredImageSize int64          `json:"expired_image_size"`
-	TotalImageSize   int64          `json:"total_image_size"`
+	Repo             RepositoryName   `json:"repository"`
+	Type             string           `json:"type"`
+	ExpiredImages    int64            `json:"expired_images"`
+	TotalImages      int64            `json:"total_images"`
+	ExpiredImageSize int64            `json:"expired_image_size"`
+	TotalImageSize   int64            `json:"total_image_size"`
+	Config           *EffectiveConfig `json:"config,omitempty"`
+}
+
+// EffectiveConfig represents the repository config applied to the repository.
+type EffectiveConfig struct {
+	Expires          string   `json:"expires"`
+	KeepCount        int64    `json:"keep_count"`
+	KeepTagPatterns  []string `json:"keep_tag_patterns"`
+	OverriddenByTags []string `json:"overridden_by_tags,omitempty"`
+}
+
+func (c *EffectiveConfig) row(repo RepositoryName) []string {
+	return []string{
+		string(repo),
+		c.Expires,
+		fmt.Sprintf("%d", c.KeepCount),
+		strings.Join(c.KeepTagPatterns, " "),
+		strings.Join(c.OverriddenByTags, ", "),
+	}
 }
 
 func (s *Summary) printable() bool {
-	if s.Type == SummaryTypeImageIndex || s.Type == SummaryTypeSociIndex {
+	if s.Type == SummaryTypeImageIndex || s.Type == SummaryTypeSociIndex || s.Type == SummaryTypeReferrer {
 		return s.TotalImages > 0
 	}
 	return true
@@ -172,6 +232,28 @@ func (s SummaryTable) printTable(w io.Writer) error {
 		}
 	}
 	t.Render()
+	return s.printOverriddenConfigs(w)
+}
+
+// printOverriddenConfigs prints the effective configs of the repositories overridden by the repository tags.
+func (s SummaryTable) printOverriddenConfigs(w io.Writer) error {
+	printed := newSet()
+	var rows [][]string
+	for _, sum := range s {
+		if sum.Config == nil || len(sum.Config.OverriddenByTags) == 0 || !printed.add(string(sum.Repo)) {
+			continue
+		}
+		rows = append(rows, sum.Config.row(sum.Repo))
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	t := tablewriter.NewWriter(w)
+	t.SetHeader([]string{"repository", "expires", "keep_count", "keep_tag_patterns", "overridden by tags"})
+	t.SetBorder(false)
+	t.AppendBulk(rows)
+	t.Render()
 	return nil
 }
 
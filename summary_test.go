@@ -0,0 +1,28 @@
+package ecrm_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/fujiwara/ecrm"
+)
+
+func TestRepoSummaryReferrer(t *testing.T) {
+	s := ecrm.NewRepoSummary("app")
+	img := ecrTypes.ImageDetail{ImageSizeInBytes: aws.Int64(100)}
+	s.AddReferrer(img)
+	s.AddReferrer(img)
+	s.ExpireReferrer(img)
+	for _, sum := range s {
+		if sum.Type != ecrm.SummaryTypeReferrer {
+			if sum.TotalImages != 0 || sum.ExpiredImages != 0 {
+				t.Errorf("%s must not be counted: %#v", sum.Type, sum)
+			}
+			continue
+		}
+		if sum.TotalImages != 2 || sum.TotalImageSize != 200 || sum.ExpiredImages != 1 || sum.ExpiredImageSize != 100 {
+			t.Errorf("unexpected referrer summary: %#v", sum)
+		}
+	}
+}
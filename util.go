@@ -37,7 +37,7 @@ func isSociIndex(d ecrTypes.ImageDetail) bool {
 	return ociTypes.MediaType(aws.ToString(d.ArtifactMediaType)) == MediaTypeSociIndex
 }
 
-func taskDefinitionFamilies(ctx context.Context, client *ecs.Client) ([]string, error) {
+func taskDefinitionFamilies(ctx context.Context, client ECSClient) ([]string, error) {
 	tds := make([]string, 0)
 	p := ecs.NewListTaskDefinitionFamiliesPaginator(client, &ecs.ListTaskDefinitionFamiliesInput{})
 	for p.HasMorePages() {
@@ -51,7 +51,7 @@ func taskDefinitionFamilies(ctx context.Context, client *ecs.Client) ([]string,
 	return tds, nil
 }
 
-func clusterArns(ctx context.Context, client *ecs.Client) ([]string, error) {
+func clusterArns(ctx context.Context, client ECSClient) ([]string, error) {
 	clusters := make([]string, 0)
 	p := ecs.NewListClustersPaginator(client, &ecs.ListClustersInput{})
 	for p.HasMorePages() {
@@ -64,7 +64,7 @@ func clusterArns(ctx context.Context, client *ecs.Client) ([]string, error) {
 	return clusters, nil
 }
 
-func lambdaFunctions(ctx context.Context, client *lambda.Client) ([]lambdaTypes.FunctionConfiguration, error) {
+func lambdaFunctions(ctx context.Context, client LambdaClient) ([]lambdaTypes.FunctionConfiguration, error) {
 	fns := make([]lambdaTypes.FunctionConfiguration, 0)
 	p := lambda.NewListFunctionsPaginator(client, &lambda.ListFunctionsInput{})
 	for p.HasMorePages() {
@@ -83,7 +83,7 @@ func lambdaFunctions(ctx context.Context, client *lambda.Client) ([]lambdaTypes.
 	return fns, nil
 }
 
-func ecrRepositories(ctx context.Context, client *ecr.Client) ([]ecrTypes.Repository, error) {
+func ecrRepositories(ctx context.Context, client ECRClient) ([]ecrTypes.Repository, error) {
 	repos := make([]ecrTypes.Repository, 0)
 	p := ecr.NewDescribeRepositoriesPaginator(client, &ecr.DescribeRepositoriesInput{})
 	for p.HasMorePages() {